@@ -0,0 +1,209 @@
+package collect
+
+import (
+	"encoding/json"
+	"net/http"
+	"os"
+	"path/filepath"
+	"strings"
+	"sync/atomic"
+	"time"
+)
+
+// DiskCache 保存在磁盘上的响应缓存, 以请求的唯一识别码为键, 参考 Request.Unique
+type DiskCache struct {
+	dir    string
+	hits   atomic.Int64
+	misses atomic.Int64
+}
+
+// cacheEntry 缓存的响应
+type cacheEntry struct {
+	Url         string      `json:"url"`
+	StatusCode  int         `json:"status_code"`
+	Header      http.Header `json:"header"`
+	ContentType string      `json:"content_type"`
+	Body        []byte      `json:"body"`
+	FetchTime   time.Time   `json:"fetch_time"` // 最近一次从服务器确认内容的时间
+}
+
+func NewDiskCache(dir string) (*DiskCache, error) {
+	if err := os.MkdirAll(dir, 0755); err != nil {
+		return nil, err
+	}
+	return &DiskCache{dir: dir}, nil
+}
+
+func (c *DiskCache) path(key string) string {
+	if len(key) < 2 {
+		return filepath.Join(c.dir, key+".json")
+	}
+	return filepath.Join(c.dir, key[:2], key+".json")
+}
+
+func (c *DiskCache) get(key string) (*cacheEntry, bool) {
+	b, err := os.ReadFile(c.path(key))
+	if err != nil {
+		return nil, false
+	}
+	var e cacheEntry
+	if err := json.Unmarshal(b, &e); err != nil {
+		return nil, false
+	}
+	return &e, true
+}
+
+func (c *DiskCache) set(key string, e *cacheEntry) error {
+	b, err := json.Marshal(e)
+	if err != nil {
+		return err
+	}
+	path := c.path(key)
+	if err := os.MkdirAll(filepath.Dir(path), 0755); err != nil {
+		return err
+	}
+	tmp := path + ".tmp"
+	if err := os.WriteFile(tmp, b, 0644); err != nil {
+		return err
+	}
+	return os.Rename(tmp, path)
+}
+
+// Remove 删除请求对应的缓存
+func (c *DiskCache) Remove(req *Request) error {
+	err := os.Remove(c.path(req.Unique()))
+	if os.IsNotExist(err) {
+		return nil
+	}
+	return err
+}
+
+// Hits 直接使用缓存或服务器返回 304 的次数
+func (c *DiskCache) Hits() int64 {
+	return c.hits.Load()
+}
+
+// Misses 需要重新下载的次数
+func (c *DiskCache) Misses() int64 {
+	return c.misses.Load()
+}
+
+// newCacheEntry 保存响应, Cookie 由会话保存, 不写入缓存
+func newCacheEntry(resp *Response) *cacheEntry {
+	header := resp.Header.Clone()
+	header.Del("Set-Cookie")
+	return &cacheEntry{
+		Url:         resp.Url,
+		StatusCode:  resp.StatusCode,
+		Header:      header,
+		ContentType: resp.ContentType,
+		Body:        resp.Body,
+		FetchTime:   resp.FetchTime,
+	}
+}
+
+func (e *cacheEntry) response(req *Request) *Response {
+	return &Response{
+		Request:     req,
+		StatusCode:  e.StatusCode,
+		Header:      e.Header,
+		Url:         e.Url,
+		ContentType: e.ContentType,
+		Body:        e.Body,
+		FetchTime:   e.FetchTime,
+		Cached:      true,
+	}
+}
+
+// cacheable 只缓存 GET 请求
+func cacheable(req *Request) bool {
+	return req.method() == "GET"
+}
+
+// storable 只缓存通过任务校验且没有禁止缓存的响应
+func storable(resp *Response) bool {
+	if strings.Contains(resp.Header.Get("Cache-Control"), "no-store") {
+		return false
+	}
+	if resp.Request != nil && resp.Request.Task != nil {
+		return resp.Request.Task.validate(resp) == nil
+	}
+	return resp.StatusCode == http.StatusOK
+}
+
+// Cache 使用磁盘缓存响应。缓存时间不超过 TTL 时直接返回缓存的响应, 任务的 CacheTTL 优先于 ttl;
+// 超过后携带 If-None-Match 和 If-Modified-Since 重新请求, 服务器返回 304 时使用缓存的响应并刷新缓存时间。
+// ttl 为 0 时每次都会发送条件请求
+func Cache(c *DiskCache, ttl time.Duration) Middleware {
+	return func(next Fetcher) Fetcher {
+		return FetcherFunc(func(req *Request) (*Response, error) {
+			if !cacheable(req) {
+				return next.Get(req)
+			}
+			ttl := ttl
+			if req.Task != nil && req.Task.CacheTTL != 0 {
+				ttl = req.Task.CacheTTL
+			}
+			if ttl < 0 {
+				return next.Get(req)
+			}
+
+			key := req.Unique()
+			entry, ok := c.get(key)
+			if ok && time.Since(entry.FetchTime) < ttl {
+				c.hits.Add(1)
+				return entry.response(req), nil
+			}
+
+			r := req
+			if ok {
+				etag, modified := entry.Header.Get("ETag"), entry.Header.Get("Last-Modified")
+				if etag != "" || modified != "" {
+					r = withHeader(req, func(h http.Header) {
+						if etag != "" {
+							h.Set("If-None-Match", etag)
+						}
+						if modified != "" {
+							h.Set("If-Modified-Since", modified)
+						}
+					})
+				}
+			}
+			start := time.Now()
+			resp, err := next.Get(r)
+			if err != nil {
+				return nil, err
+			}
+
+			if ok && resp.StatusCode == http.StatusNotModified {
+				c.hits.Add(1)
+				// 使用 304 响应中的验证信息更新缓存
+				if entry.Header == nil {
+					entry.Header = http.Header{}
+				}
+				for _, k := range []string{"ETag", "Last-Modified", "Cache-Control", "Expires"} {
+					if v := resp.Header.Get(k); v != "" {
+						entry.Header.Set(k, v)
+					}
+				}
+				entry.FetchTime = start
+				if err := c.set(key, entry); err != nil {
+					return nil, err
+				}
+				cached := entry.response(req)
+				cached.Duration = resp.Duration
+				return cached, nil
+			}
+
+			c.misses.Add(1)
+			if storable(resp) {
+				entry := newCacheEntry(resp)
+				entry.FetchTime = start
+				if err := c.set(key, entry); err != nil {
+					return nil, err
+				}
+			}
+			return resp, nil
+		})
+	}
+}
@@ -0,0 +1,66 @@
+package collect_test
+
+import (
+	"github.com/funbinary/crawler/collect"
+	"github.com/stretchr/testify/require"
+	"net/http"
+	"net/http/httptest"
+	"sync/atomic"
+	"testing"
+	"time"
+)
+
+func TestCache(t *testing.T) {
+	var requests, notModified atomic.Int64
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		requests.Add(1)
+		w.Header().Set("ETag", `"v1"`)
+		if r.Header.Get("If-None-Match") == `"v1"` {
+			notModified.Add(1)
+			w.WriteHeader(http.StatusNotModified)
+			return
+		}
+		w.Header().Set("Content-Type", "text/html; charset=utf-8")
+		w.Write([]byte("<html>" + r.URL.Path + "</html>"))
+	}))
+	defer server.Close()
+
+	cache, err := collect.NewDiskCache(t.TempDir())
+	require.NoError(t, err)
+	f := collect.Chain(&collect.BrowserFetch{Timeout: time.Second}, collect.Cache(cache, time.Hour))
+	task := &collect.Task{}
+	get := func(path string) *collect.Response {
+		resp, err := f.Get(&collect.Request{Task: task, Url: server.URL + path})
+		require.NoError(t, err)
+		return resp
+	}
+
+	resp := get("/a")
+	require.False(t, resp.Cached)
+	require.Equal(t, "<html>/a</html>", string(resp.Body))
+
+	// 缓存未过期时不请求服务器
+	resp = get("/a")
+	require.True(t, resp.Cached)
+	require.Equal(t, "<html>/a</html>", string(resp.Body))
+	require.Equal(t, "text/html", resp.ContentType)
+	require.Equal(t, int64(1), requests.Load())
+
+	// 过期后发送条件请求, 304 时使用缓存
+	task.CacheTTL = time.Nanosecond
+	resp = get("/a")
+	require.True(t, resp.Cached)
+	require.Equal(t, 200, resp.StatusCode)
+	require.Equal(t, "<html>/a</html>", string(resp.Body))
+	require.Equal(t, int64(2), requests.Load())
+	require.Equal(t, int64(1), notModified.Load())
+	require.Equal(t, int64(2), cache.Hits())
+	require.Equal(t, int64(1), cache.Misses())
+
+	// 禁用缓存的任务每次都请求服务器
+	task.CacheTTL = -1
+	resp = get("/a")
+	require.False(t, resp.Cached)
+	require.Equal(t, int64(3), requests.Load())
+	require.Equal(t, int64(1), notModified.Load())
+}
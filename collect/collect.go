@@ -3,94 +3,158 @@ package collect
 import (
 	"bufio"
 	"fmt"
-	extensions "github.com/funbinary/crawler/extentions"
 	"github.com/funbinary/crawler/proxy"
 	"github.com/pkg/errors"
 	"go.uber.org/zap"
 	"golang.org/x/net/html/charset"
 	"golang.org/x/text/encoding"
 	"golang.org/x/text/encoding/unicode"
-	"golang.org/x/text/transform"
-	"io"
 	"net/http"
+	"strconv"
+	"sync"
 	"time"
 )
 
 type Fetcher interface {
-	Get(url *Request) ([]byte, error)
+	Get(url *Request) (*Response, error)
+}
+
+// StatusError 服务器返回了非预期的状态码
+type StatusError struct {
+	Code       int
+	RetryAfter time.Duration // 429/503 响应中 Retry-After 要求的等待时间
+}
+
+func (e *StatusError) Error() string {
+	return fmt.Sprintf("Error status code:%v", e.Code)
+}
+
+func NewStatusError(resp *Response) *StatusError {
+	e := &StatusError{Code: resp.StatusCode}
+	if resp.StatusCode == http.StatusTooManyRequests || resp.StatusCode == http.StatusServiceUnavailable {
+		e.RetryAfter = ParseRetryAfter(resp.Header.Get("Retry-After"))
+	}
+	return e
+}
+
+// ParseRetryAfter 解析 Retry-After, 支持秒数和 HTTP 日期两种格式
+func ParseRetryAfter(v string) time.Duration {
+	if v == "" {
+		return 0
+	}
+	if seconds, err := strconv.Atoi(v); err == nil {
+		if seconds < 0 {
+			return 0
+		}
+		return time.Duration(seconds) * time.Second
+	}
+	if t, err := http.ParseTime(v); err == nil {
+		if d := time.Until(t); d > 0 {
+			return d
+		}
+	}
+	return 0
 }
 
 type BaseFetch struct {
+	Guard *NetworkGuard // 禁止访问内网地址, 为空时不限制
+
+	once   sync.Once
+	client *http.Client
 }
 
-func (b *BaseFetch) Get(req *Request) ([]byte, error) {
+func (b *BaseFetch) Client() *http.Client {
+	b.once.Do(func() {
+		b.client = http.DefaultClient
+		if b.Guard != nil {
+			b.client = &http.Client{Transport: TransportConfig{Guard: b.Guard}.NewTransport(nil)}
+		}
+	})
+	return b.client
+}
 
-	resp, err := http.Get(req.Url)
+func (b *BaseFetch) Get(request *Request) (*Response, error) {
+	req, err := request.HTTPRequest()
+	if err != nil {
+		return nil, err
+	}
+	start := time.Now()
+	resp, err := b.Client().Do(req)
 	if err != nil {
 		return nil, errors.Wrap(err, "fetch url error")
 	}
 
 	defer resp.Body.Close()
-	if resp.StatusCode != http.StatusOK {
-		return nil, errors.Errorf("Error status code:%v", resp.StatusCode)
-	}
-
-	r := bufio.NewReader(resp.Body)
-	e := DeterminEncoding(r)
-	utf8r := transform.NewReader(r, e.NewDecoder())
-	return io.ReadAll(utf8r)
+	return ReadResponse(request, resp, start)
 }
 
+// BrowserFetch 通过代理访问网站, 请求头、User-Agent 和 Cookie 等由中间件设置, 参考 Chain
 type BrowserFetch struct {
-	Timeout time.Duration
-	Proxy   proxy.ProxyFunc
-	Logger  *zap.Logger
+	Timeout   time.Duration
+	Proxy     proxy.ProxyFunc
+	Logger    *zap.Logger
+	Transport TransportConfig // 连接池配置, 在第一次请求时生效
+
+	once   sync.Once
+	client *http.Client
 }
 
-func (b *BrowserFetch) Get(request *Request) ([]byte, error) {
-	client := &http.Client{
-		Timeout: b.Timeout,
-	}
+// Client 所有 worker 共享的 http.Client, 第一次调用时根据配置创建
+func (b *BrowserFetch) Client() *http.Client {
+	b.once.Do(func() {
+		b.client = &http.Client{
+			Timeout:   b.Timeout,
+			Transport: b.Transport.NewTransport(b.Proxy),
+		}
+	})
+	return b.client
+}
 
-	if b.Proxy != nil {
-		transport := http.DefaultTransport.(*http.Transport)
-		transport.Proxy = b.Proxy
-		client.Transport = transport
-	}
-	fmt.Println("Get", request.Url)
-	req, err := http.NewRequest("GET", request.Url, nil)
+// CloseIdleConnections 关闭连接池中的空闲连接
+func (b *BrowserFetch) CloseIdleConnections() {
+	b.Client().CloseIdleConnections()
+}
+
+func (b *BrowserFetch) Get(request *Request) (*Response, error) {
+	client := b.Client()
+	req, err := request.HTTPRequest()
 	if err != nil {
 		return nil, err
 	}
-	if len(request.Task.Cookie) > 0 {
-		req.Header.Set("Cookie", request.Task.Cookie)
+
+	if request.Session != nil {
+		// 复制 Client 以使用会话的 Cookie, Transport 仍然是共享的
+		c := *client
+		c.Jar = request.Session
+		client = &c
 	}
-	req.Header.Set("User-Agent", extensions.GenerateRandomUA())
-	//req.Header.Set("User-Agent", "Mozilla/5.0 (Macintosh; Intel Mac OS X 10_15_4) AppleWebKit/537.36 (KHTML, like Gecko) Chrome/80.0.3987.149 Safari/537.36")
 
+	req, proxyUsed := withProxyUsed(req)
+	start := time.Now()
 	resp, err := client.Do(req)
 	if err != nil {
 		return nil, err
 	}
-	time.Sleep(request.Task.WaitTime)
-	fmt.Println("Get", request.Url, "success")
 	defer resp.Body.Close()
-	if resp.StatusCode != http.StatusOK {
-		return nil, errors.Errorf("Error status code:%v", resp.StatusCode)
+	response, err := ReadResponse(request, resp, start)
+	if err != nil {
+		return nil, err
 	}
-
-	r := bufio.NewReader(resp.Body)
-	e := DeterminEncoding(r)
-	utf8r := transform.NewReader(r, e.NewDecoder())
-	return io.ReadAll(utf8r)
+	response.Proxy = *proxyUsed
+	return response, nil
 }
 
 func DeterminEncoding(r *bufio.Reader) encoding.Encoding {
+	return DeterminEncodingWithType(r, "")
+}
+
+// DeterminEncodingWithType 根据响应头中的 Content-Type 和响应体的前 1024 个字节判断编码
+func DeterminEncodingWithType(r *bufio.Reader, contentType string) encoding.Encoding {
 	bytes, err := r.Peek(1024)
-	if err != nil {
+	if err != nil && len(bytes) == 0 {
 		return unicode.UTF8
 	}
 
-	e, _, _ := charset.DetermineEncoding(bytes, "")
+	e, _, _ := charset.DetermineEncoding(bytes, contentType)
 	return e
 }
@@ -0,0 +1,62 @@
+package collect_test
+
+import (
+	"github.com/funbinary/crawler/collect"
+	"github.com/stretchr/testify/require"
+	"net"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"sync"
+	"sync/atomic"
+	"testing"
+	"time"
+)
+
+func TestBrowserFetchTransport(t *testing.T) {
+	var conns atomic.Int64
+	server := httptest.NewUnstartedServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set("Content-Type", "text/html; charset=utf-8")
+		w.Write([]byte("<html>ok</html>"))
+	}))
+	server.Config.ConnState = func(c net.Conn, state http.ConnState) {
+		if state == http.StateNew {
+			conns.Add(1)
+		}
+	}
+	server.Start()
+	defer server.Close()
+
+	var proxied atomic.Int64
+	f := &collect.BrowserFetch{
+		Timeout: time.Second,
+		Proxy: func(r *http.Request) (*url.URL, error) {
+			proxied.Add(1)
+			return nil, nil
+		},
+		Transport: collect.TransportConfig{MaxIdleConnsPerHost: 4},
+	}
+
+	var wg sync.WaitGroup
+	for i := 0; i < 4; i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			for j := 0; j < 10; j++ {
+				resp, err := f.Get(&collect.Request{Url: server.URL})
+				require.NoError(t, err)
+				require.Equal(t, "text/html", resp.ContentType)
+				require.Equal(t, "<html>ok</html>", string(resp.Body))
+			}
+		}()
+	}
+	wg.Wait()
+	require.Equal(t, int64(40), proxied.Load())
+	// 连接被复用, 而不是每次请求都新建
+	require.LessOrEqual(t, conns.Load(), int64(4))
+
+	// 代理设置不会影响默认的 Transport
+	_, err := http.Get(server.URL)
+	require.NoError(t, err)
+	require.Equal(t, int64(40), proxied.Load())
+}
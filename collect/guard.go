@@ -0,0 +1,197 @@
+package collect
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"net"
+	"net/http"
+	"net/url"
+	"strings"
+	"sync"
+	"syscall"
+	"time"
+)
+
+// ErrForbiddenAddress 请求的地址被 NetworkGuard 禁止访问
+var ErrForbiddenAddress = errors.New("forbidden address")
+
+// 默认禁止访问的网段: 本机、内网、链路本地(包括云服务器的元数据地址)、运营商级 NAT、组播和保留地址
+var DefaultDeniedNetworks = []string{
+	"0.0.0.0/8",
+	"10.0.0.0/8",
+	"100.64.0.0/10",
+	"127.0.0.0/8",
+	"169.254.0.0/16",
+	"172.16.0.0/12",
+	"192.0.0.0/24",
+	"192.168.0.0/16",
+	"198.18.0.0/15",
+	"224.0.0.0/4",
+	"240.0.0.0/4",
+	"::/128",
+	"::1/128",
+	"fc00::/7",
+	"fe80::/10",
+	"ff00::/8",
+}
+
+// NetworkGuard 禁止抓取器访问本机和内网地址, 避免抓取到的链接或动态规则访问内部服务。
+// 直接连接时在建立连接前检查解析后的 IP, 因此对重定向和 DNS 重新绑定同样有效。
+// 使用代理时只有连接本次请求选择的代理不受限制, 目标域名在选择代理时解析并检查一次,
+// 代理自己解析域名, 因此这种情况下无法防止 DNS 重新绑定
+type NetworkGuard struct {
+	Allow []string // 允许访问的 IP 或网段, 优先于禁止的网段, 如测试服务器所在的 127.0.0.1
+	Deny  []string // 额外禁止的 IP 或网段, DefaultDeniedNetworks 总是被禁止
+
+	once  sync.Once
+	allow []*net.IPNet
+	deny  []*net.IPNet
+	err   error
+}
+
+func (g *NetworkGuard) init() error {
+	g.once.Do(func() {
+		if g.allow, g.err = parseNetworks(g.Allow); g.err != nil {
+			return
+		}
+		deny := append([]string{}, DefaultDeniedNetworks...)
+		g.deny, g.err = parseNetworks(append(deny, g.Deny...))
+	})
+	return g.err
+}
+
+func parseNetworks(list []string) ([]*net.IPNet, error) {
+	var nets []*net.IPNet
+	for _, s := range list {
+		if !strings.Contains(s, "/") {
+			ip := net.ParseIP(s)
+			if ip == nil {
+				return nil, fmt.Errorf("invalid ip %q", s)
+			}
+			bits := 128
+			if ip.To4() != nil {
+				ip, bits = ip.To4(), 32
+			}
+			nets = append(nets, &net.IPNet{IP: ip, Mask: net.CIDRMask(bits, bits)})
+			continue
+		}
+		_, n, err := net.ParseCIDR(s)
+		if err != nil {
+			return nil, err
+		}
+		nets = append(nets, n)
+	}
+	return nets, nil
+}
+
+// CheckIP 判断是否可以访问 ip
+func (g *NetworkGuard) CheckIP(ip net.IP) error {
+	if err := g.init(); err != nil {
+		return err
+	}
+	if v4 := ip.To4(); v4 != nil {
+		ip = v4
+	}
+	for _, n := range g.allow {
+		if n.Contains(ip) {
+			return nil
+		}
+	}
+	for _, n := range g.deny {
+		if n.Contains(ip) {
+			return fmt.Errorf("%w: %s", ErrForbiddenAddress, ip)
+		}
+	}
+	return nil
+}
+
+// CheckHost 解析 host 并判断所有地址是否都可以访问
+func (g *NetworkGuard) CheckHost(ctx context.Context, host string) error {
+	if ip := net.ParseIP(host); ip != nil {
+		return g.CheckIP(ip)
+	}
+	addrs, err := net.DefaultResolver.LookupIPAddr(ctx, host)
+	if err != nil {
+		return err
+	}
+	for _, addr := range addrs {
+		if err := g.CheckIP(addr.IP); err != nil {
+			return fmt.Errorf("%s: %w", host, err)
+		}
+	}
+	return nil
+}
+
+// control 在建立连接之前检查解析后的地址, 用于 net.Dialer.Control
+func (g *NetworkGuard) control(network, address string, _ syscall.RawConn) error {
+	host, _, err := net.SplitHostPort(address)
+	if err != nil {
+		return err
+	}
+	ip := net.ParseIP(host)
+	if ip == nil {
+		return fmt.Errorf("%w: %s", ErrForbiddenAddress, address)
+	}
+	return g.CheckIP(ip)
+}
+
+type guardDialKey struct{}
+
+// guardDial 记录一次请求选择的代理, 只有连接该代理时才跳过地址检查
+type guardDial struct {
+	proxy string // 代理的 host:port, 没有使用代理时为空
+}
+
+// dialContext 连接请求选择的代理时不检查地址, 其它连接都检查解析后的地址
+func (g *NetworkGuard) dialContext(dialer *net.Dialer) func(ctx context.Context, network, addr string) (net.Conn, error) {
+	checked := *dialer
+	checked.Control = g.control
+	return func(ctx context.Context, network, addr string) (net.Conn, error) {
+		if d, ok := ctx.Value(guardDialKey{}).(*guardDial); ok && d.proxy != "" && d.proxy == addr {
+			return dialer.DialContext(ctx, network, addr)
+		}
+		return checked.DialContext(ctx, network, addr)
+	}
+}
+
+// proxy 检查请求的目标地址并记录选择的代理, 由代理连接目标地址时只能在这里检查一次
+func (g *NetworkGuard) proxy(p func(*http.Request) (*url.URL, error)) func(*http.Request) (*url.URL, error) {
+	return func(r *http.Request) (*url.URL, error) {
+		u, err := p(r)
+		if err != nil || u == nil {
+			return u, err
+		}
+		ctx, cancel := context.WithTimeout(r.Context(), 10*time.Second)
+		defer cancel()
+		if err := g.CheckHost(ctx, r.URL.Hostname()); err != nil {
+			return nil, err
+		}
+		if d, ok := r.Context().Value(guardDialKey{}).(*guardDial); ok {
+			d.proxy = proxyAddr(u)
+		}
+		return u, nil
+	}
+}
+
+// proxyAddr 与 http.Transport 连接代理时使用的地址相同
+func proxyAddr(u *url.URL) string {
+	port := u.Port()
+	if port == "" {
+		port = map[string]string{"https": "443", "socks5": "1080"}[u.Scheme]
+		if port == "" {
+			port = "80"
+		}
+	}
+	return net.JoinHostPort(u.Hostname(), port)
+}
+
+// guardTransport 为每次请求(包括重定向)创建独立的 guardDial
+type guardTransport struct {
+	*http.Transport
+}
+
+func (t guardTransport) RoundTrip(r *http.Request) (*http.Response, error) {
+	ctx := context.WithValue(r.Context(), guardDialKey{}, &guardDial{})
+	return t.Transport.RoundTrip(r.WithContext(ctx))
+}
@@ -0,0 +1,92 @@
+package collect_test
+
+import (
+	"errors"
+	"github.com/funbinary/crawler/collect"
+	"github.com/stretchr/testify/require"
+	"net"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"testing"
+	"time"
+)
+
+func TestNetworkGuard(t *testing.T) {
+	g := &collect.NetworkGuard{Allow: []string{"10.1.2.3"}, Deny: []string{"203.0.113.0/24"}}
+	for ip, forbidden := range map[string]bool{
+		"8.8.8.8":          false,
+		"127.0.0.1":        true,
+		"::ffff:127.0.0.1": true,
+		"::1":              true,
+		"10.0.0.1":         true,
+		"10.1.2.3":         false,
+		"172.20.0.1":       true,
+		"192.168.1.1":      true,
+		"169.254.169.254":  true,
+		"fd00:ec2::254":    true,
+		"203.0.113.7":      true,
+	} {
+		err := g.CheckIP(net.ParseIP(ip))
+		require.Equal(t, forbidden, errors.Is(err, collect.ErrForbiddenAddress), ip)
+	}
+	require.Error(t, (&collect.NetworkGuard{Allow: []string{"bad"}}).CheckIP(net.ParseIP("8.8.8.8")))
+}
+
+func TestFetchGuard(t *testing.T) {
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.URL.Path == "/redirect" {
+			http.Redirect(w, r, "http://169.254.169.254/latest/meta-data/", http.StatusFound)
+			return
+		}
+		w.Write([]byte("ok"))
+	}))
+	defer server.Close()
+
+	// 默认禁止访问本机
+	f := &collect.BrowserFetch{Timeout: time.Second, Transport: collect.TransportConfig{Guard: &collect.NetworkGuard{}}}
+	_, err := f.Get(&collect.Request{Url: server.URL})
+	require.True(t, errors.Is(err, collect.ErrForbiddenAddress), err)
+	require.Equal(t, collect.ErrorForbidden, collect.ClassifyError(err))
+	require.False(t, collect.DefaultRetryPolicy.ShouldRetry(1, err))
+
+	b := &collect.BaseFetch{Guard: &collect.NetworkGuard{}}
+	_, err = b.Get(&collect.Request{Url: server.URL})
+	require.True(t, errors.Is(err, collect.ErrForbiddenAddress), err)
+
+	// 允许访问测试服务器, 但重定向到的元数据地址仍然被禁止
+	f = &collect.BrowserFetch{Timeout: time.Second, Transport: collect.TransportConfig{Guard: &collect.NetworkGuard{Allow: []string{"127.0.0.1"}}}}
+	resp, err := f.Get(&collect.Request{Url: server.URL})
+	require.NoError(t, err)
+	require.Equal(t, "ok", string(resp.Body))
+	_, err = f.Get(&collect.Request{Url: server.URL + "/redirect"})
+	require.True(t, errors.Is(err, collect.ErrForbiddenAddress), err)
+
+	// 本机的代理可以使用, 但通过代理访问内网地址被禁止
+	proxyUrl, _ := url.Parse(server.URL)
+	f = &collect.BrowserFetch{
+		Timeout: time.Second,
+		Proxy: func(r *http.Request) (*url.URL, error) {
+			return proxyUrl, nil
+		},
+		Transport: collect.TransportConfig{Guard: &collect.NetworkGuard{}},
+	}
+	resp, err = f.Get(&collect.Request{Url: "http://93.184.216.34/"})
+	require.NoError(t, err)
+	require.Equal(t, "ok", string(resp.Body))
+	_, err = f.Get(&collect.Request{Url: "http://10.0.0.1/"})
+	require.True(t, errors.Is(err, collect.ErrForbiddenAddress), err)
+
+	// 使用过的代理地址在不经过代理直接访问时仍然被禁止
+	f.Proxy = func(r *http.Request) (*url.URL, error) {
+		if r.URL.Host == proxyUrl.Host {
+			return nil, nil
+		}
+		return proxyUrl, nil
+	}
+	f = &collect.BrowserFetch{Timeout: time.Second, Proxy: f.Proxy, Transport: f.Transport}
+	resp, err = f.Get(&collect.Request{Url: "http://93.184.216.34/"})
+	require.NoError(t, err)
+	_, err = f.Get(&collect.Request{Url: server.URL})
+	require.True(t, errors.Is(err, collect.ErrForbiddenAddress), err)
+}
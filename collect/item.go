@@ -0,0 +1,60 @@
+package collect
+
+import "time"
+
+// FieldType 数据字段的类型
+type FieldType string
+
+const (
+	FieldString FieldType = "string"
+	FieldInt    FieldType = "int"
+	FieldFloat  FieldType = "float"
+	FieldBool   FieldType = "bool"
+	FieldTime   FieldType = "time"
+)
+
+// Field 规则输出数据中的一个字段
+type Field struct {
+	Name string    `json:"name"`
+	Type FieldType `json:"type"`
+}
+
+// DataCell 规则解析后输出的一条结构化数据
+type DataCell struct {
+	Task *Task
+	Req  *Request
+	Rule string                 // 输出数据的规则名
+	Time time.Time              // 抓取时间
+	Data map[string]interface{} // 字段名 -> 字段值
+}
+
+func (d *DataCell) GetTaskName() string {
+	if d.Task == nil {
+		return ""
+	}
+	return d.Task.Name
+}
+
+// GetTableName 数据存储的表名, 由任务名和规则名组成
+func (d *DataCell) GetTableName() string {
+	return d.GetTaskName() + "_" + d.Rule
+}
+
+func (d *DataCell) GetUrl() string {
+	if d.Req == nil {
+		return ""
+	}
+	return d.Req.Url
+}
+
+// Schema 输出该数据的规则声明的字段, 未声明时返回 nil
+func (d *DataCell) Schema() []Field {
+	if d.Task == nil {
+		return nil
+	}
+	rule, ok := d.Task.Rule.Trunk[d.Rule]
+	if !ok {
+		return nil
+	}
+	return rule.ItemFields
+}
@@ -0,0 +1,112 @@
+package collect
+
+import (
+	"encoding/json"
+	"fmt"
+)
+
+// 以下方法在第一次使用时将响应体解码为 JSON, 动态规则中可以通过 ctx 直接调用,
+// 如 ctx.JSONStrings("$.data[*].title")。表达式的语法参考 JSONPath
+
+// JSON 解码后的响应体
+func (c *Context) JSON() (interface{}, error) {
+	if !c.jsonParsed {
+		c.jsonParsed = true
+		if err := json.Unmarshal(c.Body, &c.json); err != nil {
+			c.jsonErr = fmt.Errorf("decode json body error: %w", err)
+		}
+	}
+	return c.json, c.jsonErr
+}
+
+// JSONPath 在响应体中查询, 返回所有匹配的值
+func (c *Context) JSONPath(expr string) ([]interface{}, error) {
+	v, err := c.JSON()
+	if err != nil {
+		return nil, err
+	}
+	return JSONPath(v, expr)
+}
+
+// JSONValues 所有匹配的值, 响应体不是 JSON 或表达式不合法时没有匹配结果
+func (c *Context) JSONValues(expr string) []interface{} {
+	values, _ := c.JSONPath(expr)
+	return values
+}
+
+// JSONValue 第一个匹配的值, 没有匹配时返回 nil
+func (c *Context) JSONValue(expr string) interface{} {
+	values := c.JSONValues(expr)
+	if len(values) == 0 {
+		return nil
+	}
+	return values[0]
+}
+
+// JSONString 第一个匹配的值转换成的字符串, 对象和数组转换为 JSON
+func (c *Context) JSONString(expr string) string {
+	return jsonString(c.JSONValue(expr))
+}
+
+// JSONStrings 所有匹配的值转换成的字符串
+func (c *Context) JSONStrings(expr string) []string {
+	var strs []string
+	for _, v := range c.JSONValues(expr) {
+		strs = append(strs, jsonString(v))
+	}
+	return strs
+}
+
+// JSONRequests 将匹配的值作为 URL, 生成 name 规则的子请求, 相对地址会转换为绝对地址
+func (c *Context) JSONRequests(expr string, name string) []*Request {
+	var reqs []*Request
+	for _, u := range c.JSONStrings(expr) {
+		if u == "" {
+			continue
+		}
+		reqs = append(reqs, c.Req.Child(c.AbsUrl(u), name))
+	}
+	return reqs
+}
+
+// JSONItems 对每个匹配 expr 的值生成一条数据, fields 为字段名到相对于该值的表达式的映射,
+// 如 JSONItems("$.data[*]", map[string]string{"title": "@.title"})
+func (c *Context) JSONItems(expr string, fields map[string]string) ([]*DataCell, error) {
+	values, err := c.JSONPath(expr)
+	if err != nil {
+		return nil, err
+	}
+	items := make([]*DataCell, 0, len(values))
+	for _, v := range values {
+		data := make(map[string]interface{}, len(fields))
+		for name, sub := range fields {
+			matches, err := JSONPath(v, sub)
+			if err != nil {
+				return nil, err
+			}
+			if len(matches) > 0 {
+				data[name] = matches[0]
+			}
+		}
+		items = append(items, c.Output(data))
+	}
+	return items, nil
+}
+
+// ParseJSJSON 用于动态规则, 将匹配的值作为 name 规则的请求
+func (c *Context) ParseJSJSON(name string, expr string) ParseResult {
+	return ParseResult{Requesrts: c.JSONRequests(expr, name)}
+}
+
+// OutputJSJSON 用于动态规则, 对每个匹配 expr 的值输出一条数据, 参考 JSONItems
+func (c *Context) OutputJSJSON(expr string, fields map[string]interface{}) ParseResult {
+	f := make(map[string]string, len(fields))
+	for k, v := range fields {
+		f[k] = fmt.Sprint(v)
+	}
+	items, _ := c.JSONItems(expr, f)
+	if items == nil {
+		items = []*DataCell{}
+	}
+	return ParseResult{Items: items}
+}
@@ -0,0 +1,481 @@
+package collect
+
+import (
+	"encoding/json"
+	"fmt"
+	"reflect"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+)
+
+// JSONPath 表达式, 支持以下语法:
+//
+//	$ 或 @          根节点, 可以省略, 如 data.items 等同于 $.data.items
+//	.name ['name']  对象的字段, 引号中可以包含任意字符, 使用 \ 转义
+//	[0] [-1]        数组的元素, 负数表示从末尾开始
+//	[0,2] ['a','b'] 多个元素或字段
+//	[1:3] [::-1]    数组切片, 步长为负数时倒序
+//	* [*]           所有字段或元素
+//	..name ..[0]    递归查找, 后面可以是任意一种字段或元素的写法
+//	[?(@.price < 10)] [?(@.isbn)] 过滤, 支持 == != < <= > >= 和字段是否存在
+type jsonPath []jsonStep
+
+type jsonStep struct {
+	recursive bool // 前面是 ..
+	wildcard  bool
+	names     []string
+	indexes   []int
+	slice     *jsonSlice
+	filter    *jsonFilter
+}
+
+type jsonSlice struct {
+	start, end, step *int
+}
+
+type jsonFilter struct {
+	path  jsonPath
+	op    string // 为空时只判断字段是否存在
+	value interface{}
+}
+
+// 编译后的表达式, 避免每次查询都重新解析
+var jsonPathCache sync.Map
+
+// JSONPath 在已经解码的 JSON 值中查询, 返回所有匹配的值
+func JSONPath(v interface{}, expr string) ([]interface{}, error) {
+	p, err := compileJSONPath(expr)
+	if err != nil {
+		return nil, err
+	}
+	return p.eval(v), nil
+}
+
+func compileJSONPath(expr string) (jsonPath, error) {
+	if p, ok := jsonPathCache.Load(expr); ok {
+		return p.(jsonPath), nil
+	}
+	p, err := parseJSONPath(expr)
+	if err != nil {
+		return nil, fmt.Errorf("invalid jsonpath %q: %w", expr, err)
+	}
+	jsonPathCache.Store(expr, p)
+	return p, nil
+}
+
+func parseJSONPath(expr string) (jsonPath, error) {
+	s := strings.TrimSpace(expr)
+	if strings.HasPrefix(s, "$") || strings.HasPrefix(s, "@") {
+		s = s[1:]
+	} else if s != "" && s[0] != '.' && s[0] != '[' {
+		s = "." + s
+	}
+
+	var p jsonPath
+	for len(s) > 0 {
+		var step jsonStep
+		switch {
+		case strings.HasPrefix(s, ".."):
+			step.recursive = true
+			s = s[2:]
+			if strings.HasPrefix(s, "[") {
+				break
+			}
+			s = "." + s
+			fallthrough
+		case s[0] == '.':
+			s = s[1:]
+			end := strings.IndexAny(s, ".[")
+			if end < 0 {
+				end = len(s)
+			}
+			name := s[:end]
+			s = s[end:]
+			if name == "" {
+				return nil, fmt.Errorf("empty field name")
+			}
+			if name == "*" {
+				step.wildcard = true
+			} else {
+				step.names = []string{name}
+			}
+			p = append(p, step)
+			continue
+		case s[0] != '[':
+			return nil, fmt.Errorf("unexpected %q", s)
+		}
+
+		end := closingBracket(s)
+		if end < 0 {
+			return nil, fmt.Errorf("missing ]")
+		}
+		if err := step.parseBracket(strings.TrimSpace(s[1:end])); err != nil {
+			return nil, err
+		}
+		s = s[end+1:]
+		p = append(p, step)
+	}
+	return p, nil
+}
+
+// closingBracket 与 s[0] 的 [ 对应的 ] 的位置, 忽略引号和括号中的字符
+func closingBracket(s string) int {
+	depth := 0
+	var quote byte
+	for i := 0; i < len(s); i++ {
+		c := s[i]
+		switch {
+		case quote != 0:
+			if c == '\\' {
+				i++
+			} else if c == quote {
+				quote = 0
+			}
+		case c == '\'' || c == '"':
+			quote = c
+		case c == '[' || c == '(':
+			depth++
+		case c == ']' || c == ')':
+			depth--
+			if depth == 0 && c == ']' {
+				return i
+			}
+		}
+	}
+	return -1
+}
+
+// splitTopLevel 按 sep 分割, 忽略引号中的 sep
+func splitTopLevel(s string, sep byte) []string {
+	var parts []string
+	var quote byte
+	start := 0
+	for i := 0; i < len(s); i++ {
+		c := s[i]
+		switch {
+		case quote != 0:
+			if c == '\\' {
+				i++
+			} else if c == quote {
+				quote = 0
+			}
+		case c == '\'' || c == '"':
+			quote = c
+		case c == sep:
+			parts = append(parts, strings.TrimSpace(s[start:i]))
+			start = i + 1
+		}
+	}
+	return append(parts, strings.TrimSpace(s[start:]))
+}
+
+func (step *jsonStep) parseBracket(s string) error {
+	switch {
+	case s == "*":
+		step.wildcard = true
+		return nil
+	case strings.HasPrefix(s, "?(") && strings.HasSuffix(s, ")"):
+		f, err := parseJSONFilter(strings.TrimSpace(s[2 : len(s)-1]))
+		step.filter = f
+		return err
+	}
+
+	if parts := splitTopLevel(s, ':'); len(parts) > 1 {
+		if len(parts) > 3 {
+			return fmt.Errorf("invalid slice %q", s)
+		}
+		step.slice = &jsonSlice{}
+		bounds := []**int{&step.slice.start, &step.slice.end, &step.slice.step}
+		for i, part := range parts {
+			if part == "" {
+				continue
+			}
+			n, err := strconv.Atoi(part)
+			if err != nil {
+				return fmt.Errorf("invalid slice %q", s)
+			}
+			*bounds[i] = &n
+		}
+		return nil
+	}
+
+	for _, part := range splitTopLevel(s, ',') {
+		if name, ok := unquote(part); ok {
+			step.names = append(step.names, name)
+			continue
+		}
+		n, err := strconv.Atoi(part)
+		if err != nil {
+			return fmt.Errorf("invalid index %q", part)
+		}
+		step.indexes = append(step.indexes, n)
+	}
+	return nil
+}
+
+func unquote(s string) (string, bool) {
+	if len(s) < 2 || (s[0] != '\'' && s[0] != '"') || s[len(s)-1] != s[0] {
+		return "", false
+	}
+	if s[0] == '"' {
+		v, err := strconv.Unquote(s)
+		return v, err == nil
+	}
+	// 单引号中的 \' 和 \\ 转义为 ' 和 \
+	var b strings.Builder
+	body := s[1 : len(s)-1]
+	for i := 0; i < len(body); i++ {
+		if body[i] == '\\' && i+1 < len(body) {
+			i++
+		}
+		b.WriteByte(body[i])
+	}
+	return b.String(), true
+}
+
+func parseJSONFilter(s string) (*jsonFilter, error) {
+	f := &jsonFilter{}
+	left := s
+	for _, op := range []string{"==", "!=", "<=", ">=", "<", ">"} {
+		if i := indexTopLevel(s, op); i >= 0 {
+			f.op = op
+			left = strings.TrimSpace(s[:i])
+			right := strings.TrimSpace(s[i+len(op):])
+			if v, ok := unquote(right); ok {
+				f.value = v
+			} else if err := json.Unmarshal([]byte(right), &f.value); err != nil {
+				return nil, fmt.Errorf("invalid filter value %q", right)
+			}
+			break
+		}
+	}
+	if !strings.HasPrefix(left, "@") {
+		return nil, fmt.Errorf("filter must start with @: %q", s)
+	}
+	p, err := parseJSONPath(left)
+	f.path = p
+	return f, err
+}
+
+// indexTopLevel sub 在引号之外第一次出现的位置
+func indexTopLevel(s string, sub string) int {
+	var quote byte
+	for i := 0; i < len(s); i++ {
+		c := s[i]
+		switch {
+		case quote != 0:
+			if c == '\\' {
+				i++
+			} else if c == quote {
+				quote = 0
+			}
+		case c == '\'' || c == '"':
+			quote = c
+		case strings.HasPrefix(s[i:], sub):
+			return i
+		}
+	}
+	return -1
+}
+
+func (p jsonPath) eval(root interface{}) []interface{} {
+	nodes := []interface{}{root}
+	for _, step := range p {
+		var next []interface{}
+		for _, n := range nodes {
+			if step.recursive {
+				for _, d := range descendants(n, nil) {
+					next = step.match(d, next)
+				}
+			} else {
+				next = step.match(n, next)
+			}
+		}
+		nodes = next
+	}
+	return nodes
+}
+
+// descendants 按先序遍历 n 及其所有子节点, 对象的字段按名称排序
+func descendants(n interface{}, out []interface{}) []interface{} {
+	out = append(out, n)
+	for _, c := range children(n) {
+		out = descendants(c, out)
+	}
+	return out
+}
+
+func children(n interface{}) []interface{} {
+	switch v := n.(type) {
+	case map[string]interface{}:
+		keys := make([]string, 0, len(v))
+		for k := range v {
+			keys = append(keys, k)
+		}
+		sort.Strings(keys)
+		values := make([]interface{}, 0, len(v))
+		for _, k := range keys {
+			values = append(values, v[k])
+		}
+		return values
+	case []interface{}:
+		return v
+	}
+	return nil
+}
+
+func (step *jsonStep) match(n interface{}, out []interface{}) []interface{} {
+	switch {
+	case step.wildcard:
+		return append(out, children(n)...)
+	case step.filter != nil:
+		for _, c := range children(n) {
+			if step.filter.match(c) {
+				out = append(out, c)
+			}
+		}
+		return out
+	case len(step.names) > 0:
+		if m, ok := n.(map[string]interface{}); ok {
+			for _, name := range step.names {
+				if v, ok := m[name]; ok {
+					out = append(out, v)
+				}
+			}
+		}
+		return out
+	}
+
+	arr, ok := n.([]interface{})
+	if !ok {
+		return out
+	}
+	if step.slice != nil {
+		return step.slice.apply(arr, out)
+	}
+	for _, i := range step.indexes {
+		if i < 0 {
+			i += len(arr)
+		}
+		if i >= 0 && i < len(arr) {
+			out = append(out, arr[i])
+		}
+	}
+	return out
+}
+
+// apply 与 Python 的切片相同, step 为负数时从后向前取, 为 0 时不返回任何元素
+func (s *jsonSlice) apply(arr []interface{}, out []interface{}) []interface{} {
+	n := len(arr)
+	step := 1
+	if s.step != nil {
+		step = *s.step
+	}
+	if step == 0 {
+		return out
+	}
+	// 负数表示从末尾开始, 再限制在 [min, max] 之间
+	bound := func(p *int, def, min, max int) int {
+		if p == nil {
+			return def
+		}
+		i := *p
+		if i < 0 {
+			i += n
+		}
+		if i < min {
+			return min
+		}
+		if i > max {
+			return max
+		}
+		return i
+	}
+	if step > 0 {
+		for i := bound(s.start, 0, 0, n); i < bound(s.end, n, 0, n); i += step {
+			out = append(out, arr[i])
+		}
+		return out
+	}
+	for i := bound(s.start, n-1, -1, n-1); i > bound(s.end, -1, -1, n-1); i += step {
+		out = append(out, arr[i])
+	}
+	return out
+}
+
+func (f *jsonFilter) match(n interface{}) bool {
+	values := f.path.eval(n)
+	if f.op == "" {
+		return len(values) > 0
+	}
+	// 与 RFC 9535 相同, 字段不存在时不等于任何值
+	if len(values) == 0 {
+		return f.op == "!="
+	}
+	for _, v := range values {
+		if compareJSON(v, f.op, f.value) {
+			return true
+		}
+	}
+	return false
+}
+
+func compareJSON(a interface{}, op string, b interface{}) bool {
+	switch op {
+	case "==":
+		return reflect.DeepEqual(a, b)
+	case "!=":
+		return !reflect.DeepEqual(a, b)
+	}
+	var c int
+	switch x := a.(type) {
+	case float64:
+		y, ok := b.(float64)
+		if !ok {
+			return false
+		}
+		switch {
+		case x < y:
+			c = -1
+		case x > y:
+			c = 1
+		}
+	case string:
+		y, ok := b.(string)
+		if !ok {
+			return false
+		}
+		c = strings.Compare(x, y)
+	default:
+		return false
+	}
+	switch op {
+	case "<":
+		return c < 0
+	case "<=":
+		return c <= 0
+	case ">":
+		return c > 0
+	case ">=":
+		return c >= 0
+	}
+	return false
+}
+
+// jsonString 将 JSON 值转换为字符串, 字符串不带引号, null 为空字符串
+func jsonString(v interface{}) string {
+	switch x := v.(type) {
+	case nil:
+		return ""
+	case string:
+		return x
+	case float64:
+		return strconv.FormatFloat(x, 'f', -1, 64)
+	case bool:
+		return strconv.FormatBool(x)
+	}
+	b, _ := json.Marshal(v)
+	return string(b)
+}
@@ -0,0 +1,181 @@
+package collect_test
+
+import (
+	"encoding/json"
+	"github.com/funbinary/crawler/collect"
+	"github.com/stretchr/testify/require"
+	"testing"
+)
+
+const jsonBody = `{
+  "total": 3,
+  "data": [
+    {"id": 1, "title": "带阳台", "url": "/topic/1/", "tags": ["南向", "阳台"], "price": 2500},
+    {"id": 2, "title": "次卧", "url": "https://www.douban.com/topic/2/", "price": 1800},
+    {"id": 3, "title": "主卧 o'clock", "url": "/topic/3/", "price": 3200, "author": {"name": "a"}}
+  ],
+  "next": "/api/list?start=3"
+}`
+
+func TestJSONPath(t *testing.T) {
+	var v interface{}
+	require.NoError(t, json.Unmarshal([]byte(jsonBody), &v))
+
+	cases := []struct {
+		expr string
+		want []interface{}
+	}{
+		{"$.total", []interface{}{3.0}},
+		{"total", []interface{}{3.0}},
+		{"$.data[0].title", []interface{}{"带阳台"}},
+		{"$['data'][-1]['id']", []interface{}{3.0}},
+		{"$.data[*].id", []interface{}{1.0, 2.0, 3.0}},
+		{"$.data.*.id", []interface{}{1.0, 2.0, 3.0}},
+		{"$.data[0,2].id", []interface{}{1.0, 3.0}},
+		{"$.data[1:].id", []interface{}{2.0, 3.0}},
+		{"$.data[::2].id", []interface{}{1.0, 3.0}},
+		{"$..name", []interface{}{"a"}},
+		{"$..tags[1]", []interface{}{"阳台"}},
+		{"$.data[?(@.price < 2000)].id", []interface{}{2.0}},
+		{"$.data[?(@.price >= 2500)].id", []interface{}{1.0, 3.0}},
+		{"$.data[?(@.title == '不存在')].id", nil},
+		{`$.data[?(@.title == "主卧 o'clock")].id`, []interface{}{3.0}},
+		{"$.data[?(@.author)].id", []interface{}{3.0}},
+		{"$.missing", nil},
+		{"$.data[10]", nil},
+	}
+	for _, c := range cases {
+		got, err := collect.JSONPath(v, c.expr)
+		require.NoError(t, err, c.expr)
+		require.Equal(t, c.want, got, c.expr)
+	}
+
+	for _, expr := range []string{"$.data[", "$.data[x]", "$.data[?(price < 1)]", "$..", "$.data[1:2:3:4]"} {
+		_, err := collect.JSONPath(v, expr)
+		require.Error(t, err, expr)
+	}
+}
+
+func TestContextJSON(t *testing.T) {
+	req := &collect.Request{Url: "https://www.douban.com/api/list", Depth: 1}
+	ctx := &collect.Context{
+		Body: []byte(jsonBody),
+		Req:  req,
+		Resp: &collect.Response{Request: req, Url: req.Url},
+	}
+
+	require.Equal(t, "3", ctx.JSONString("$.total"))
+	require.Equal(t, []string{"带阳台", "次卧", "主卧 o'clock"}, ctx.JSONStrings("$.data[*].title"))
+	require.Equal(t, `["南向","阳台"]`, ctx.JSONString("$.data[0].tags"))
+	require.Nil(t, ctx.JSONValue("$.missing"))
+
+	reqs := ctx.JSONRequests("$.data[*].url", "detail")
+	require.Len(t, reqs, 3)
+	require.Equal(t, "https://www.douban.com/topic/1/", reqs[0].Url)
+	require.Equal(t, "https://www.douban.com/topic/2/", reqs[1].Url)
+	require.Equal(t, int64(2), reqs[0].Depth)
+	require.Equal(t, "detail", reqs[0].RuleName)
+
+	items, err := ctx.JSONItems("$.data[?(@.price > 2000)]", map[string]string{"title": "@.title", "price": "price", "author": "@.author.name"})
+	require.NoError(t, err)
+	require.Len(t, items, 2)
+	require.Equal(t, map[string]interface{}{"title": "带阳台", "price": 2500.0}, items[0].Data)
+	require.Equal(t, map[string]interface{}{"title": "主卧 o'clock", "price": 3200.0, "author": "a"}, items[1].Data)
+
+	result := ctx.OutputJSJSON("$.data[*]", map[string]interface{}{"id": "@.id"})
+	require.Len(t, result.Items, 3)
+	result = ctx.ParseJSJSON("list", "$.next")
+	require.Equal(t, "https://www.douban.com/api/list?start=3", result.Requesrts[0].Url)
+
+	ctx = &collect.Context{Body: []byte("<html></html>"), Req: req}
+	_, err = ctx.JSON()
+	require.Error(t, err)
+	require.Empty(t, ctx.JSONStrings("$.data"))
+	require.Empty(t, ctx.OutputJSJSON("$.data", nil).Items)
+}
+
+func TestJSONPathEdgeCases(t *testing.T) {
+	var v interface{}
+	require.NoError(t, json.Unmarshal([]byte(`{
+  "list": [0, 1, 2, 3, 4],
+  "a]b": 1,
+  "c,d": 2,
+  "e:f": 3,
+  "g'h": 4,
+  "i\\j": 5,
+  "nested": {"name": "x", "items": [{"name": "y"}, {"k": [10, 11]}]},
+  "books": [
+    {"title": "a", "price": 8, "tags": ["x"], "flag": true},
+    {"title": "b", "price": 12, "tags": ["y", "x"], "flag": false},
+    {"title": "c)d", "price": 20},
+    {"title": "e]f", "price": null}
+  ]
+}`), &v))
+
+	cases := []struct {
+		expr string
+		want []interface{}
+	}{
+		// 递归查找与中括号一起使用
+		{"$..name", []interface{}{"x", "y"}},
+		{"$..['name']", []interface{}{"x", "y"}},
+		{`$..["name"]`, []interface{}{"x", "y"}},
+		{"$.nested..[0]", []interface{}{map[string]interface{}{"name": "y"}, 10.0}},
+		{"$.nested..[-1]", []interface{}{map[string]interface{}{"k": []interface{}{10.0, 11.0}}, 11.0}},
+		{"$.nested..k[1]", []interface{}{11.0}},
+		{"$.nested.items..*", []interface{}{
+			map[string]interface{}{"name": "y"},
+			map[string]interface{}{"k": []interface{}{10.0, 11.0}},
+			"y", []interface{}{10.0, 11.0}, 10.0, 11.0,
+		}},
+
+		// 切片
+		{"$.list[1:3]", []interface{}{1.0, 2.0}},
+		{"$.list[-2:]", []interface{}{3.0, 4.0}},
+		{"$.list[:-3]", []interface{}{0.0, 1.0}},
+		{"$.list[-10:10]", []interface{}{0.0, 1.0, 2.0, 3.0, 4.0}},
+		{"$.list[3:1]", nil},
+		{"$.list[::-1]", []interface{}{4.0, 3.0, 2.0, 1.0, 0.0}},
+		{"$.list[::-2]", []interface{}{4.0, 2.0, 0.0}},
+		{"$.list[3:0:-1]", []interface{}{3.0, 2.0, 1.0}},
+		{"$.list[-1:-3:-1]", []interface{}{4.0, 3.0}},
+		{"$.list[1:3:-1]", nil},
+		{"$.list[10:-10:-2]", []interface{}{4.0, 2.0, 0.0}},
+		{"$.list[::0]", nil},
+
+		// 带有特殊字符的字段名
+		{"$['a]b']", []interface{}{1.0}},
+		{"$['c,d']", []interface{}{2.0}},
+		{"$['e:f']", []interface{}{3.0}},
+		{`$['g\'h']`, []interface{}{4.0}},
+		{`$["g'h"]`, []interface{}{4.0}},
+		{`$['i\\j']`, []interface{}{5.0}},
+		{"$['a]b','c,d']", []interface{}{1.0, 2.0}},
+		{"$..['a]b']", []interface{}{1.0}},
+
+		// 过滤
+		{"$.books[?(@.price < 10)].title", []interface{}{"a"}},
+		{"$.books[?(@.price > 10)].title", []interface{}{"b", "c)d"}},
+		{"$.books[?(@.price == null)].title", []interface{}{"e]f"}},
+		{"$.books[?(@.flag == true)].title", []interface{}{"a"}},
+		{"$.books[?(@.flag != true)].title", []interface{}{"b", "c)d", "e]f"}},
+		{"$.books[?(@.tags)].title", []interface{}{"a", "b"}},
+		{"$.books[?(@.tags[1] == 'x')].title", []interface{}{"b"}},
+		{"$.books[?(@.title == 'c)d')].price", []interface{}{20.0}},
+		{"$.books[?(@.title == 'e]f')].title", []interface{}{"e]f"}},
+		{"$.books[?(@['title'] >= 'b')].title", []interface{}{"b", "c)d", "e]f"}},
+		{"$.books[?(@.price < 'x')].title", nil},
+		{"$..[?(@.name == 'y')]", []interface{}{map[string]interface{}{"name": "y"}}},
+		{"$.nested[?(@ == 'x')]", []interface{}{"x"}},
+	}
+	for _, c := range cases {
+		got, err := collect.JSONPath(v, c.expr)
+		require.NoError(t, err, c.expr)
+		require.Equal(t, c.want, got, c.expr)
+	}
+
+	for _, expr := range []string{"$['a]b'", "$['a", "$[?(@.a == )]", "$[1:x]", "$[?(@.a == 'x']"} {
+		_, err := collect.JSONPath(v, expr)
+		require.Error(t, err, expr)
+	}
+}
@@ -0,0 +1,205 @@
+package collect
+
+import (
+	"fmt"
+	"github.com/PuerkitoBio/goquery"
+	"net/url"
+	"regexp"
+	"strings"
+)
+
+// LinkExtractor 从页面中提取链接并生成子请求, 链接会相对于 <base> 或响应的最终 URL 转换为绝对地址,
+// 只保留 http 和 https 链接, 同一页面中重复的链接只保留一个
+type LinkExtractor struct {
+	Selector    string   // 链接元素的 CSS 选择器, 为空时使用 a[href], area[href]
+	Attr        string   // 链接所在的属性, 为空时使用 href
+	Allow       []string // 链接必须匹配其中一个正则表达式, 为空时不限制
+	Deny        []string // 链接匹配其中一个正则表达式时丢弃
+	Domains     []string // 允许的域名, 包括子域名, 为空时不限制
+	DenyDomains []string // 不允许的域名, 包括子域名
+	MaxDepth    int64    // 子请求的最大深度, 为 0 时只受任务的 MaxDepth 限制
+	RuleName    string   // 子请求使用的规则
+	// 将链接元素的属性保存到子请求的 Meta 中, 键为 Meta 中的字段名, 值为属性名, 属性名为空时保存链接的文本
+	MetaAttrs map[string]string
+}
+
+// 提取链接时的默认选择器
+const DefaultLinkSelector = "a[href], area[href]"
+
+// Links 使用 e 从页面中提取链接, 生成子请求
+func (c *Context) Links(e LinkExtractor) []*Request {
+	if e.MaxDepth > 0 && c.Req.Depth+1 > e.MaxDepth {
+		return nil
+	}
+	selector, attr := e.Selector, e.Attr
+	if selector == "" {
+		selector = DefaultLinkSelector
+	}
+	if attr == "" {
+		attr = "href"
+	}
+
+	base, err := url.Parse(c.BaseUrl())
+	if err != nil {
+		return nil
+	}
+	var reqs []*Request
+	seen := make(map[string]bool)
+	c.Find(selector).Each(func(_ int, s *goquery.Selection) {
+		ref, ok := s.Attr(attr)
+		if !ok {
+			return
+		}
+		u, ok := resolveLink(base, ref)
+		if !ok || seen[u.String()] || !e.allowed(u) {
+			return
+		}
+		seen[u.String()] = true
+		req := c.Req.Child(u.String(), e.RuleName)
+		for key, name := range e.MetaAttrs {
+			if req.Meta == nil {
+				req.Meta = make(map[string]interface{})
+			}
+			if name == "" {
+				req.Meta[key] = strings.TrimSpace(s.Text())
+			} else if v, ok := s.Attr(name); ok {
+				req.Meta[key] = v
+			}
+		}
+		reqs = append(reqs, req)
+	})
+	return reqs
+}
+
+// ExtractLinksJS 用于动态规则提取链接, m 的字段与 LinkExtractor 相同, 如 {RuleName: "detail", Allow: ["/topic/"]}
+func (c *Context) ExtractLinksJS(m map[string]interface{}) ParseResult {
+	e := LinkExtractor{
+		Selector:    jsString(m["Selector"]),
+		Attr:        jsString(m["Attr"]),
+		Allow:       jsStrings(m["Allow"]),
+		Deny:        jsStrings(m["Deny"]),
+		Domains:     jsStrings(m["Domains"]),
+		DenyDomains: jsStrings(m["DenyDomains"]),
+		RuleName:    jsString(m["RuleName"]),
+	}
+	switch v := m["MaxDepth"].(type) {
+	case int64:
+		e.MaxDepth = v
+	case float64:
+		e.MaxDepth = int64(v)
+	}
+	if meta, ok := m["MetaAttrs"].(map[string]interface{}); ok {
+		e.MetaAttrs = make(map[string]string, len(meta))
+		for k, v := range meta {
+			e.MetaAttrs[k] = jsString(v)
+		}
+	}
+	return ParseResult{Requesrts: c.Links(e)}
+}
+
+func jsString(v interface{}) string {
+	if v == nil {
+		return ""
+	}
+	return fmt.Sprint(v)
+}
+
+func jsStrings(v interface{}) []string {
+	switch x := v.(type) {
+	case string:
+		return []string{x}
+	case []string:
+		return x
+	case []interface{}:
+		strs := make([]string, 0, len(x))
+		for _, s := range x {
+			strs = append(strs, jsString(s))
+		}
+		return strs
+	}
+	return nil
+}
+
+// BaseUrl 页面中相对地址的基准, 页面中有 <base href> 时使用它, 否则使用响应的最终 URL
+func (c *Context) BaseUrl() string {
+	if c.base == "" {
+		c.base = c.baseUrl()
+	}
+	return c.base
+}
+
+func (c *Context) baseUrl() string {
+	base := c.Req.FullUrl()
+	if c.Resp != nil && c.Resp.Url != "" {
+		base = c.Resp.Url
+	}
+	if !baseTag.Match(c.Body) {
+		return base
+	}
+	href, ok := c.Find("base[href]").First().Attr("href")
+	if !ok {
+		return base
+	}
+	b, err := url.Parse(base)
+	if err != nil {
+		return base
+	}
+	u, err := b.Parse(strings.TrimSpace(href))
+	if err != nil {
+		return base
+	}
+	return u.String()
+}
+
+// 页面中可能存在 <base> 时才需要解析文档
+var baseTag = regexp.MustCompile(`(?i)<base[\s>]`)
+
+// resolveLink 将链接转换为绝对地址, 只接受 http 和 https 链接, 去掉锚点
+func resolveLink(base *url.URL, ref string) (*url.URL, bool) {
+	ref = strings.TrimSpace(ref)
+	if ref == "" || strings.HasPrefix(ref, "#") {
+		return nil, false
+	}
+	u, err := base.Parse(ref)
+	if err != nil || (u.Scheme != "http" && u.Scheme != "https") || u.Host == "" {
+		return nil, false
+	}
+	u.Fragment = ""
+	return u, true
+}
+
+func (e *LinkExtractor) allowed(u *url.URL) bool {
+	host := strings.ToLower(u.Hostname())
+	if len(e.Domains) > 0 && !matchDomain(host, e.Domains) {
+		return false
+	}
+	if matchDomain(host, e.DenyDomains) {
+		return false
+	}
+	link := []byte(u.String())
+	for _, p := range e.Deny {
+		if match(p, link) {
+			return false
+		}
+	}
+	if len(e.Allow) == 0 {
+		return true
+	}
+	for _, p := range e.Allow {
+		if match(p, link) {
+			return true
+		}
+	}
+	return false
+}
+
+// matchDomain host 是否为 domains 中的域名或其子域名
+func matchDomain(host string, domains []string) bool {
+	for _, d := range domains {
+		d = strings.ToLower(strings.TrimPrefix(d, "."))
+		if host == d || strings.HasSuffix(host, "."+d) {
+			return true
+		}
+	}
+	return false
+}
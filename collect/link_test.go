@@ -0,0 +1,72 @@
+package collect_test
+
+import (
+	"github.com/funbinary/crawler/collect"
+	"github.com/stretchr/testify/require"
+	"testing"
+)
+
+const linkPage = `<html><head><base href="/group/szsh/"></head><body>
+<a href="topic/1/#comments" title="带阳台">带阳台...</a>
+<a href="topic/1/">重复</a>
+<a href="https://www.douban.com/group/topic/2/?_i=1"> 次卧 </a>
+<a href="https://accounts.douban.com/login">登录</a>
+<a href="https://example.com/topic/3/">外站</a>
+<a href="javascript:void(0)">更多</a>
+<a href="mailto:a@douban.com">邮件</a>
+<a href="#top">顶部</a>
+<map><area href="/group/topic/4/"></map>
+</body></html>`
+
+func TestLinks(t *testing.T) {
+	// 重定向之后的最终 URL 与请求的 URL 不同
+	req := &collect.Request{Url: "http://douban.com/group/szsh", Depth: 1, Meta: map[string]interface{}{"city": "sz"}}
+	ctx := &collect.Context{
+		Body: []byte(linkPage),
+		Req:  req,
+		Resp: &collect.Response{Request: req, Url: "https://www.douban.com/group/szsh/discussion"},
+	}
+	require.Equal(t, "https://www.douban.com/group/szsh/", ctx.BaseUrl())
+	require.Equal(t, "https://www.douban.com/group/szsh/topic/5/", ctx.AbsUrl("topic/5/"))
+
+	urls := func(reqs []*collect.Request) []string {
+		var us []string
+		for _, r := range reqs {
+			us = append(us, r.Url)
+		}
+		return us
+	}
+
+	reqs := ctx.Links(collect.LinkExtractor{RuleName: "detail"})
+	require.Equal(t, []string{
+		"https://www.douban.com/group/szsh/topic/1/",
+		"https://www.douban.com/group/topic/2/?_i=1",
+		"https://accounts.douban.com/login",
+		"https://example.com/topic/3/",
+		"https://www.douban.com/group/topic/4/",
+	}, urls(reqs))
+	require.Equal(t, "detail", reqs[0].RuleName)
+	require.Equal(t, int64(2), reqs[0].Depth)
+	require.Equal(t, "sz", reqs[0].Meta["city"])
+
+	reqs = ctx.Links(collect.LinkExtractor{
+		Selector:    "a",
+		Allow:       []string{`/topic/\d+/`},
+		Deny:        []string{`_i=`},
+		Domains:     []string{"douban.com"},
+		DenyDomains: []string{"accounts.douban.com"},
+		MetaAttrs:   map[string]string{"title": "title", "text": ""},
+	})
+	require.Equal(t, []string{"https://www.douban.com/group/szsh/topic/1/"}, urls(reqs))
+	require.Equal(t, "带阳台", reqs[0].Meta["title"])
+	require.Equal(t, "带阳台...", reqs[0].Meta["text"])
+
+	require.Empty(t, ctx.Links(collect.LinkExtractor{MaxDepth: 1}))
+
+	result := ctx.ExtractLinksJS(map[string]interface{}{
+		"RuleName": "detail",
+		"Domains":  []interface{}{"example.com"},
+		"MaxDepth": 2.0,
+	})
+	require.Equal(t, []string{"https://example.com/topic/3/"}, urls(result.Requesrts))
+}
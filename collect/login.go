@@ -0,0 +1,70 @@
+package collect
+
+import (
+	"fmt"
+	"net/url"
+)
+
+// LoginFunc 任务的登录流程, 通过 LoginContext 发送的请求会将服务器设置的 Cookie 保存到会话中。
+// 登录失败时返回错误, 会话保持过期状态, 下次使用时重新登录
+type LoginFunc func(ctx *LoginContext) error
+
+// LoginContext 登录流程中使用的会话和抓取器
+type LoginContext struct {
+	Task    *Task
+	Session *Session
+	Fetcher Fetcher
+}
+
+// Do 使用会话发送请求, 状态码大于等于 400 时返回 StatusError
+func (c *LoginContext) Do(req *Request) (*Response, error) {
+	r := *req
+	r.Task = c.Task
+	r.Session = c.Session
+	resp, err := c.Fetcher.Get(&r)
+	if err != nil {
+		return nil, err
+	}
+	if resp.StatusCode >= 400 {
+		return resp, NewStatusError(resp)
+	}
+	return resp, nil
+}
+
+// Get 使用会话访问 rawurl
+func (c *LoginContext) Get(rawurl string) (*Response, error) {
+	return c.Do(&Request{Url: rawurl, Method: "GET"})
+}
+
+// PostForm 使用会话提交表单
+func (c *LoginContext) PostForm(rawurl string, form url.Values) (*Response, error) {
+	return c.Do(&Request{Url: rawurl, Method: "POST", Form: form})
+}
+
+// PostFormJS 用于动态规则提交表单
+func (c *LoginContext) PostFormJS(rawurl string, form map[string]interface{}) (*Response, error) {
+	values := url.Values{}
+	for k, v := range form {
+		values.Set(k, fmt.Sprint(v))
+	}
+	return c.PostForm(rawurl, values)
+}
+
+// PostJSON 使用会话提交 JSON 格式的请求体
+func (c *LoginContext) PostJSON(rawurl string, body interface{}) (*Response, error) {
+	return c.Do(&Request{Url: rawurl, Method: "POST", JSON: body})
+}
+
+// Cookie 会话中发送给 rawurl 的名为 name 的 Cookie 的值, 不存在时返回空字符串
+func (c *LoginContext) Cookie(rawurl string, name string) string {
+	u, err := url.Parse(rawurl)
+	if err != nil {
+		return ""
+	}
+	for _, cookie := range c.Session.Cookies(u) {
+		if cookie.Name == name {
+			return cookie.Value
+		}
+	}
+	return ""
+}
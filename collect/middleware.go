@@ -0,0 +1,203 @@
+package collect
+
+import (
+	extensions "github.com/funbinary/crawler/extentions"
+	"go.uber.org/zap"
+	"net/http"
+	"sync"
+	"sync/atomic"
+	"time"
+)
+
+// FetcherFunc 函数形式的抓取器
+type FetcherFunc func(req *Request) (*Response, error)
+
+func (f FetcherFunc) Get(req *Request) (*Response, error) {
+	return f(req)
+}
+
+// Middleware 包装抓取器, 在请求前后加入额外的处理
+type Middleware func(next Fetcher) Fetcher
+
+// Chain 使用中间件依次包装抓取器, 第一个中间件在最外层, 最先处理请求
+func Chain(f Fetcher, mws ...Middleware) Fetcher {
+	for i := len(mws) - 1; i >= 0; i-- {
+		f = mws[i](f)
+	}
+	return f
+}
+
+// withHeader 复制请求并设置请求头, 避免修改调度器中的请求, 重试时可以重新生成请求头
+func withHeader(req *Request, set func(h http.Header)) *Request {
+	r := *req
+	r.Header = req.Header.Clone()
+	if r.Header == nil {
+		r.Header = http.Header{}
+	}
+	set(r.Header)
+	return &r
+}
+
+// Headers 为请求加上默认的请求头, 请求中已经设置的请求头不会被覆盖
+func Headers(header http.Header) Middleware {
+	return func(next Fetcher) Fetcher {
+		return FetcherFunc(func(req *Request) (*Response, error) {
+			return next.Get(withHeader(req, func(h http.Header) {
+				for k, vs := range header {
+					if h.Get(k) != "" {
+						continue
+					}
+					for _, v := range vs {
+						h.Add(k, v)
+					}
+				}
+			}))
+		})
+	}
+}
+
+// RandomUA 请求中没有设置 User-Agent 时, 每次请求使用随机的 User-Agent
+func RandomUA() Middleware {
+	return func(next Fetcher) Fetcher {
+		return FetcherFunc(func(req *Request) (*Response, error) {
+			if req.Header.Get("User-Agent") != "" {
+				return next.Get(req)
+			}
+			return next.Get(withHeader(req, func(h http.Header) {
+				h.Set("User-Agent", extensions.GenerateRandomUA())
+			}))
+		})
+	}
+}
+
+// TaskCookie 请求中没有设置 Cookie 且没有使用会话时, 使用任务配置的 Cookie, 参考 Task.CookieString
+func TaskCookie() Middleware {
+	return func(next Fetcher) Fetcher {
+		return FetcherFunc(func(req *Request) (*Response, error) {
+			if req.Task == nil || req.Session != nil || req.Header.Get("Cookie") != "" {
+				return next.Get(req)
+			}
+			cookie := req.Task.CookieString()
+			if cookie == "" {
+				return next.Get(req)
+			}
+			return next.Get(withHeader(req, func(h http.Header) {
+				h.Set("Cookie", cookie)
+			}))
+		})
+	}
+}
+
+// Logging 记录每次请求的结果和耗时
+func Logging(logger *zap.Logger) Middleware {
+	return func(next Fetcher) Fetcher {
+		return FetcherFunc(func(req *Request) (*Response, error) {
+			start := time.Now()
+			resp, err := next.Get(req)
+			if err != nil {
+				logger.Warn("fetch failed",
+					zap.String("url", req.Url),
+					zap.Duration("duration", time.Since(start)),
+					zap.Error(err),
+				)
+				return resp, err
+			}
+			logger.Debug("fetch success",
+				zap.String("url", req.Url),
+				zap.Int("status", resp.StatusCode),
+				zap.Int("length", len(resp.Body)),
+				zap.Duration("duration", time.Since(start)),
+			)
+			return resp, err
+		})
+	}
+}
+
+// Retry 网络错误或超时时立即重试, 最多请求 attempts 次。
+// 与引擎的重试策略不同, 这里不会重新经过调度器。
+func Retry(attempts int, delay time.Duration) Middleware {
+	return func(next Fetcher) Fetcher {
+		return FetcherFunc(func(req *Request) (*Response, error) {
+			var resp *Response
+			var err error
+			for i := 0; i < attempts || i == 0; i++ {
+				if i > 0 {
+					select {
+					case <-time.After(delay):
+					case <-req.Context().Done():
+						return resp, err
+					}
+				}
+				resp, err = next.Get(req)
+				if err == nil {
+					return resp, nil
+				}
+				if class := ClassifyError(err); class != ErrorNetwork && class != ErrorTimeout {
+					return resp, err
+				}
+			}
+			return resp, err
+		})
+	}
+}
+
+// FetchMetrics 抓取的统计指标
+type FetchMetrics struct {
+	requests atomic.Int64
+	errors   atomic.Int64
+	bytes    atomic.Int64
+	duration atomic.Int64
+	status   sync.Map // 状态码 -> *atomic.Int64
+}
+
+// Metrics 将每次请求的结果记录到 m 中
+func Metrics(m *FetchMetrics) Middleware {
+	return func(next Fetcher) Fetcher {
+		return FetcherFunc(func(req *Request) (*Response, error) {
+			start := time.Now()
+			resp, err := next.Get(req)
+			m.requests.Add(1)
+			m.duration.Add(int64(time.Since(start)))
+			if err != nil {
+				m.errors.Add(1)
+				return resp, err
+			}
+			m.bytes.Add(int64(len(resp.Body)))
+			counter, _ := m.status.LoadOrStore(resp.StatusCode, new(atomic.Int64))
+			counter.(*atomic.Int64).Add(1)
+			return resp, err
+		})
+	}
+}
+
+func (m *FetchMetrics) Requests() int64 {
+	return m.requests.Load()
+}
+
+func (m *FetchMetrics) Errors() int64 {
+	return m.errors.Load()
+}
+
+// Bytes 响应体的总字节数
+func (m *FetchMetrics) Bytes() int64 {
+	return m.bytes.Load()
+}
+
+// AvgDuration 每次请求的平均耗时
+func (m *FetchMetrics) AvgDuration() time.Duration {
+	n := m.requests.Load()
+	if n == 0 {
+		return 0
+	}
+	return time.Duration(m.duration.Load() / n)
+}
+
+// StatusCount 各个状态码的响应数
+func (m *FetchMetrics) StatusCount() map[int]int64 {
+	count := make(map[int]int64)
+	m.status.Range(func(k, v interface{}) bool {
+		count[k.(int)] = v.(*atomic.Int64).Load()
+		return true
+	})
+	return count
+}
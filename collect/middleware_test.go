@@ -0,0 +1,52 @@
+package collect_test
+
+import (
+	"github.com/funbinary/crawler/collect"
+	"github.com/stretchr/testify/require"
+	"net/http"
+	"testing"
+)
+
+func TestChain(t *testing.T) {
+	var order []string
+	trace := func(name string) collect.Middleware {
+		return func(next collect.Fetcher) collect.Fetcher {
+			return collect.FetcherFunc(func(req *collect.Request) (*collect.Response, error) {
+				order = append(order, name)
+				return next.Get(req)
+			})
+		}
+	}
+	var got *collect.Request
+	base := collect.FetcherFunc(func(req *collect.Request) (*collect.Response, error) {
+		got = req
+		return &collect.Response{Request: req, StatusCode: 200}, nil
+	})
+
+	metrics := &collect.FetchMetrics{}
+	f := collect.Chain(base,
+		trace("a"),
+		trace("b"),
+		collect.Metrics(metrics),
+		collect.Headers(http.Header{"Accept": {"text/html"}, "Referer": {"https://douban.com"}}),
+		collect.TaskCookie(),
+		collect.RandomUA(),
+	)
+	req := &collect.Request{
+		Task:   &collect.Task{Property: collect.Property{Cookie: "bid=1"}},
+		Url:    "https://douban.com",
+		Header: http.Header{"Referer": {"https://www.douban.com/group"}},
+	}
+	_, err := f.Get(req)
+	require.NoError(t, err)
+
+	require.Equal(t, []string{"a", "b"}, order)
+	require.Equal(t, "text/html", got.Header.Get("Accept"))
+	require.Equal(t, "https://www.douban.com/group", got.Header.Get("Referer"))
+	require.Equal(t, "bid=1", got.Header.Get("Cookie"))
+	require.NotEmpty(t, got.Header.Get("User-Agent"))
+	// 中间件不会修改调度器中的请求
+	require.Empty(t, req.Header.Get("User-Agent"))
+	require.Equal(t, int64(1), metrics.Requests())
+	require.Equal(t, map[int]int64{200: 1}, metrics.StatusCount())
+}
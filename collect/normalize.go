@@ -0,0 +1,108 @@
+package collect
+
+import (
+	"net/url"
+	"sort"
+	"strings"
+)
+
+// Normalizer URL 规范化规则, 用于去掉只是写法不同的重复请求
+type Normalizer struct {
+	StripQuery          []string // 需要去掉的查询参数, 以 * 结尾时按前缀匹配, 如 utm_*
+	KeepQueryOrder      bool     // 保留查询参数的顺序, 默认按参数名排序
+	KeepFragment        bool     // 保留 # 之后的片段
+	RemoveTrailingSlash bool     // 去掉路径末尾的 /, 部分网站会因此重定向, 默认不开启
+}
+
+// 默认去掉常见的跟踪参数
+var DefaultNormalizer = &Normalizer{
+	StripQuery: []string{"utm_*", "spm", "fbclid", "gclid"},
+}
+
+var defaultPorts = map[string]string{
+	"http":  "80",
+	"https": "443",
+}
+
+// Normalize 返回规范化后的 URL:
+// scheme 和 host 转为小写, 去掉默认端口、片段、需要去掉的查询参数, 并对查询参数排序。
+func (n *Normalizer) Normalize(rawUrl string) (string, error) {
+	u, err := url.Parse(strings.TrimSpace(rawUrl))
+	if err != nil {
+		return "", err
+	}
+	u.Scheme = strings.ToLower(u.Scheme)
+	u.Host = strings.ToLower(u.Host)
+	if port := u.Port(); port != "" && defaultPorts[u.Scheme] == port {
+		u.Host = strings.TrimSuffix(u.Host, ":"+port)
+	}
+	if u.Path == "" && u.Host != "" {
+		u.Path = "/"
+	}
+	if n.RemoveTrailingSlash && len(u.Path) > 1 {
+		u.Path = strings.TrimRight(u.Path, "/")
+		u.RawPath = ""
+	}
+	if !n.KeepFragment {
+		u.Fragment = ""
+		u.RawFragment = ""
+	}
+	u.ForceQuery = false
+	if u.RawQuery != "" {
+		u.RawQuery = n.normalizeQuery(u.RawQuery)
+	}
+	return u.String(), nil
+}
+
+func (n *Normalizer) normalizeQuery(rawQuery string) string {
+	parts := strings.Split(rawQuery, "&")
+	kept := make([]string, 0, len(parts))
+	for _, part := range parts {
+		if part == "" {
+			continue
+		}
+		key := part
+		if i := strings.IndexByte(part, '='); i >= 0 {
+			key = part[:i]
+		}
+		if name, err := url.QueryUnescape(key); err == nil {
+			key = name
+		}
+		if n.strip(key) {
+			continue
+		}
+		kept = append(kept, part)
+	}
+	if !n.KeepQueryOrder {
+		// 只按参数名排序, 同名参数保持原有顺序
+		sortByKey(kept)
+	}
+	return strings.Join(kept, "&")
+}
+
+func (n *Normalizer) strip(key string) bool {
+	for _, p := range n.StripQuery {
+		if strings.HasSuffix(p, "*") {
+			if strings.HasPrefix(key, strings.TrimSuffix(p, "*")) {
+				return true
+			}
+			continue
+		}
+		if key == p {
+			return true
+		}
+	}
+	return false
+}
+
+func sortByKey(parts []string) {
+	key := func(part string) string {
+		if i := strings.IndexByte(part, '='); i >= 0 {
+			return part[:i]
+		}
+		return part
+	}
+	sort.SliceStable(parts, func(i, j int) bool {
+		return key(parts[i]) < key(parts[j])
+	})
+}
@@ -0,0 +1,39 @@
+package collect_test
+
+import (
+	"github.com/funbinary/crawler/collect"
+	"github.com/stretchr/testify/require"
+	"testing"
+)
+
+func TestNormalize(t *testing.T) {
+	tests := []struct {
+		normalizer *collect.Normalizer
+		url        string
+		want       string
+	}{
+		{collect.DefaultNormalizer, "HTTP://Www.Douban.com:80/group/topic/1/#comments", "http://www.douban.com/group/topic/1/"},
+		{collect.DefaultNormalizer, "https://douban.com:443", "https://douban.com/"},
+		{collect.DefaultNormalizer, "https://douban.com:8443/a?", "https://douban.com:8443/a"},
+		{collect.DefaultNormalizer, "https://douban.com/a?b=2&utm_source=x&a=1&b=1", "https://douban.com/a?a=1&b=2&b=1"},
+		{&collect.Normalizer{StripQuery: []string{"_i"}, KeepQueryOrder: true}, "https://douban.com/a/?z=1&_i=123&a=2", "https://douban.com/a/?z=1&a=2"},
+		{&collect.Normalizer{RemoveTrailingSlash: true, KeepFragment: true}, "https://douban.com/a/#top", "https://douban.com/a#top"},
+	}
+	for _, tt := range tests {
+		got, err := tt.normalizer.Normalize(tt.url)
+		require.NoError(t, err)
+		require.Equal(t, tt.want, got, tt.url)
+	}
+}
+
+func TestUnique(t *testing.T) {
+	a := &collect.Request{Url: "https://douban.com/a?utm_source=x&b=1#top", Method: "get"}
+	b := &collect.Request{Url: "https://DOUBAN.com/a?b=1", Method: "GET"}
+	require.Equal(t, a.Unique(), b.Unique())
+
+	b.Method = "POST"
+	require.NotEqual(t, a.Unique(), b.Unique())
+
+	c := &collect.Request{Url: b.Url, Method: "POST", Body: []byte("q=1")}
+	require.NotEqual(t, b.Unique(), c.Unique())
+}
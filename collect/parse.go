@@ -8,5 +8,6 @@ type RuleTree struct {
 
 // 采集规则节点
 type Rule struct {
-	ParseFunc func(*Context) (ParseResult, error) // 内容解析函数
+	ParseFunc  func(*Context) (ParseResult, error) // 内容解析函数
+	ItemFields []Field                             // 输出数据的字段, 存储时用于建表和生成表头
 }
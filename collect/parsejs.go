@@ -4,11 +4,13 @@ type (
 	TaskModle struct {
 		Property
 		Root  string      `json:"root_script"`
+		Login string      `json:"login_script"` // 登录脚本, 通过 ctx 发送请求, 返回 false 或抛出异常表示登录失败
 		Rules []RuleModle `json:"rule"`
 	}
 
 	RuleModle struct {
-		Name      string `json:"name"`
-		ParseFunc string `json:"parse_script"`
+		Name       string  `json:"name"`
+		ParseFunc  string  `json:"parse_script"`
+		ItemFields []Field `json:"item_fields"`
 	}
 )
@@ -0,0 +1,167 @@
+package collect
+
+import (
+	"bufio"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"os"
+	"sync"
+)
+
+// ErrNotRecorded 回放时存档中没有请求对应的响应
+var ErrNotRecorded = errors.New("request not recorded")
+
+// archiveRecord 存档中的一条记录, 每行一条 JSON
+type archiveRecord struct {
+	Key      string      `json:"key"` // 请求的唯一识别码, 参考 Request.Unique
+	Method   string      `json:"method"`
+	Url      string      `json:"url"`
+	Response *cacheEntry `json:"response"`
+}
+
+// Archive 保存抓取过程中的请求和响应, 用于离线回放
+type Archive struct {
+	mu   sync.Mutex
+	file *os.File
+	w    *bufio.Writer
+}
+
+// CreateArchive 创建存档文件, 文件已经存在时追加
+func CreateArchive(path string) (*Archive, error) {
+	file, err := os.OpenFile(path, os.O_CREATE|os.O_WRONLY|os.O_APPEND, 0644)
+	if err != nil {
+		return nil, err
+	}
+	return &Archive{file: file, w: bufio.NewWriter(file)}, nil
+}
+
+// Add 写入一对请求和响应
+func (a *Archive) Add(req *Request, resp *Response) error {
+	b, err := json.Marshal(archiveRecord{
+		Key:      req.Unique(),
+		Method:   req.method(),
+		Url:      req.FullUrl(),
+		Response: newCacheEntry(resp),
+	})
+	if err != nil {
+		return err
+	}
+	a.mu.Lock()
+	defer a.mu.Unlock()
+	if _, err := a.w.Write(append(b, '\n')); err != nil {
+		return err
+	}
+	return a.w.Flush()
+}
+
+func (a *Archive) Close() error {
+	a.mu.Lock()
+	defer a.mu.Unlock()
+	if err := a.w.Flush(); err != nil {
+		a.file.Close()
+		return err
+	}
+	return a.file.Close()
+}
+
+// Record 将抓取到的响应写入存档, 请求失败时不记录
+func Record(a *Archive) Middleware {
+	return func(next Fetcher) Fetcher {
+		return FetcherFunc(func(req *Request) (*Response, error) {
+			resp, err := next.Get(req)
+			if err != nil {
+				return nil, err
+			}
+			if err := a.Add(req, resp); err != nil {
+				return nil, fmt.Errorf("record response error: %w", err)
+			}
+			return resp, nil
+		})
+	}
+}
+
+// ReplayFetcher 从存档中返回响应, 不访问网络。
+// 同一请求有多条记录时按记录顺序依次返回, 最后一条会一直使用
+type ReplayFetcher struct {
+	Fallback Fetcher // 存档中没有对应的响应时使用, 为空时返回 ErrNotRecorded
+
+	mu      sync.Mutex
+	records map[string][]*cacheEntry
+	served  map[string]int
+}
+
+// NewReplayFetcher 读取存档文件
+func NewReplayFetcher(path string, fallback Fetcher) (*ReplayFetcher, error) {
+	file, err := os.Open(path)
+	if err != nil {
+		return nil, err
+	}
+	defer file.Close()
+
+	f := newReplayFetcher(fallback)
+	scanner := bufio.NewScanner(file)
+	scanner.Buffer(make([]byte, 64*1024), 64*1024*1024)
+	for line := 1; scanner.Scan(); line++ {
+		if len(scanner.Bytes()) == 0 {
+			continue
+		}
+		var r archiveRecord
+		if err := json.Unmarshal(scanner.Bytes(), &r); err != nil {
+			return nil, fmt.Errorf("decode archive %s line %d error: %w", path, line, err)
+		}
+		if r.Response != nil {
+			f.add(r.Key, r.Response)
+		}
+	}
+	if err := scanner.Err(); err != nil {
+		return nil, err
+	}
+	return f, nil
+}
+
+func newReplayFetcher(fallback Fetcher) *ReplayFetcher {
+	return &ReplayFetcher{
+		Fallback: fallback,
+		records:  make(map[string][]*cacheEntry),
+		served:   make(map[string]int),
+	}
+}
+
+func (f *ReplayFetcher) add(key string, entry *cacheEntry) {
+	f.records[key] = append(f.records[key], entry)
+}
+
+// Len 存档中不同请求的数量
+func (f *ReplayFetcher) Len() int {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	return len(f.records)
+}
+
+func (f *ReplayFetcher) Get(req *Request) (*Response, error) {
+	key := req.Unique()
+	f.mu.Lock()
+	entries := f.records[key]
+	var entry *cacheEntry
+	if len(entries) > 0 {
+		i := f.served[key]
+		if i >= len(entries) {
+			i = len(entries) - 1
+		}
+		entry = entries[i]
+		f.served[key] = i + 1
+	}
+	f.mu.Unlock()
+
+	if entry == nil {
+		if f.Fallback != nil {
+			return f.Fallback.Get(req)
+		}
+		return nil, fmt.Errorf("%w: %s %s", ErrNotRecorded, req.method(), req.FullUrl())
+	}
+	resp := entry.response(req)
+	// 回放的响应与正常抓取的响应相同, 不视为缓存
+	resp.Cached = false
+	return resp, nil
+}
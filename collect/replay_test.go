@@ -0,0 +1,54 @@
+package collect_test
+
+import (
+	"errors"
+	"github.com/funbinary/crawler/collect"
+	"github.com/stretchr/testify/require"
+	"net/http"
+	"net/http/httptest"
+	"path/filepath"
+	"testing"
+	"time"
+)
+
+func TestRecordReplay(t *testing.T) {
+	var n int
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		n++
+		w.Header().Set("Content-Type", "text/html; charset=utf-8")
+		w.Write([]byte("<html>" + r.URL.RequestURI() + "</html>"))
+	}))
+	defer server.Close()
+
+	path := filepath.Join(t.TempDir(), "archive.jsonl")
+	archive, err := collect.CreateArchive(path)
+	require.NoError(t, err)
+	f := collect.Chain(&collect.BrowserFetch{Timeout: time.Second}, collect.Record(archive))
+	for _, u := range []string{"/a", "/b?x=1", "/a"} {
+		_, err := f.Get(&collect.Request{Url: server.URL + u})
+		require.NoError(t, err)
+	}
+	require.NoError(t, archive.Close())
+	server.Close()
+
+	replay, err := collect.NewReplayFetcher(path, nil)
+	require.NoError(t, err)
+	require.Equal(t, 2, replay.Len())
+	resp, err := replay.Get(&collect.Request{Url: server.URL + "/b", Query: map[string][]string{"x": {"1"}}})
+	require.NoError(t, err)
+	require.Equal(t, "<html>/b?x=1</html>", string(resp.Body))
+	require.Equal(t, "text/html", resp.ContentType)
+	require.Equal(t, 200, resp.StatusCode)
+
+	_, err = replay.Get(&collect.Request{Url: server.URL + "/c"})
+	require.True(t, errors.Is(err, collect.ErrNotRecorded))
+
+	// 没有记录的请求使用 Fallback
+	replay.Fallback = collect.FetcherFunc(func(req *collect.Request) (*collect.Response, error) {
+		return &collect.Response{Request: req, StatusCode: 404}, nil
+	})
+	resp, err = replay.Get(&collect.Request{Url: server.URL + "/c"})
+	require.NoError(t, err)
+	require.Equal(t, 404, resp.StatusCode)
+	require.Equal(t, 3, n)
+}
@@ -1,10 +1,21 @@
 package collect
 
 import (
+	"bytes"
+	"context"
 	"crypto/md5"
 	"encoding/hex"
+	"encoding/json"
+	"github.com/PuerkitoBio/goquery"
+	"github.com/funbinary/crawler/dedup"
 	"github.com/funbinary/go_example/pkg/errors"
+	"golang.org/x/net/html"
+	"io"
+	"net/http"
+	"net/url"
+	"os"
 	"regexp"
+	"strings"
 	"sync"
 	"time"
 )
@@ -12,26 +23,115 @@ import (
 // 任务的公共属性
 
 type Property struct {
-	Name     string // 用户界面显示的名称（应保证唯一性）
-	Url      string // 访问的防战
-	Cookie   string
-	WaitTime time.Duration
-	Reload   bool // 网站是否可以重复爬取
-	MaxDepth int64
+	Name   string // 用户界面显示的名称（应保证唯一性）
+	Url    string // 访问的防战
+	Cookie string
+	// Cookie 的其他来源, 优先级为 CookieEnv > CookieFile > Cookie, 避免将 Cookie 写在代码中
+	CookieFile string        // 保存 Cookie 请求头的文件
+	CookieEnv  string        // 保存 Cookie 请求头的环境变量
+	WaitTime   time.Duration // 对同一站点两次请求之间的最小间隔
+	Reload     bool          // 网站是否可以重复爬取
+	MaxDepth   int64
+	Retry      *RetryPolicy // 失败后的重试策略, 为空时使用 DefaultRetryPolicy
+	// 对每个站点的访问限制, 零值表示不限制, 与引擎的全局限制同时生效
+	RateLimit     float64 // 每秒最多请求数
+	MaxConcurrent int     // 同时进行的最大请求数
+	RobotsTxt     bool    // 是否遵守 robots.txt
+	// URL 规范化规则, 为空时使用 DefaultNormalizer
+	Normalizer *Normalizer
+	// 响应的校验规则, 未通过校验的请求会按照重试策略重试
+	ValidateRule ValidateRule
+	// 会话配置, 配合 Sessions 中间件使用
+	Session SessionConfig
+	// 缓存的有效时间, 配合 Cache 中间件使用, 零值使用中间件的默认值, 小于零表示不使用缓存
+	CacheTTL time.Duration
+	// 抓取范围, 由引擎在请求进入调度器之前检查, 参考 InScope
+	AllowedDomains []string // 允许的域名, 包括子域名, 为空时不限制
+	Include        []string // URL 必须匹配其中一个正则表达式, 为空时不限制
+	Exclude        []string // URL 匹配其中一个正则表达式时丢弃
+	MaxPages       int64    // 任务最多抓取的页面数, 重复的请求和重试不计入, 0 表示不限制
 }
 
 // 任务实例
 type Task struct {
 	Property
-	Visited     map[string]bool
-	VisitedLock sync.Mutex
-	Rule        RuleTree
-	Fetcher     Fetcher
+	Rule    RuleTree
+	Fetcher Fetcher
+	Deduper dedup.Deduper // 任务使用的去重器, 为空时使用引擎的去重器
+	// 自定义的响应校验器, 在 ValidateRule 之后执行
+	Validators []Validator
+	// 任务专用的抓取器中间件, 引擎启动时包装在种子任务的 Fetcher 外层
+	Middlewares []Middleware
+	// 登录流程, 配合 Sessions 中间件使用, 在会话第一次使用前和过期后执行
+	Login LoginFunc
+
+	cookieOnce  sync.Once
+	cookie      string
+	sessionOnce sync.Once
+	sessions    *SessionPool
+	sessionErr  error
+}
+
+// CookieString 任务配置的 Cookie, 按 CookieEnv、CookieFile、Cookie 的顺序读取第一个非空值
+func (t *Task) CookieString() string {
+	t.cookieOnce.Do(func() {
+		if t.CookieEnv != "" {
+			if v := strings.TrimSpace(os.Getenv(t.CookieEnv)); v != "" {
+				t.cookie = v
+				return
+			}
+		}
+		if t.CookieFile != "" {
+			if b, err := os.ReadFile(t.CookieFile); err == nil && len(bytes.TrimSpace(b)) > 0 {
+				t.cookie = string(bytes.TrimSpace(b))
+				return
+			}
+		}
+		t.cookie = t.Cookie
+	})
+	return t.cookie
+}
+
+// SessionPool 任务的会话, 第一次调用时根据 Session 配置创建
+func (t *Task) SessionPool() (*SessionPool, error) {
+	t.sessionOnce.Do(func() {
+		t.sessions, t.sessionErr = NewSessionPool(t.Name, t.Session, ParseCookies(t.CookieString()))
+		if t.sessions != nil {
+			t.sessions.relogin = t.Login != nil
+		}
+	})
+	return t.sessions, t.sessionErr
 }
 
 type Context struct {
-	Body []byte
+	Body []byte // 即 Resp.Body
 	Req  *Request
+	Resp *Response
+
+	base string // 参考 BaseUrl
+	// 延迟解析的文档, 参考 Node
+	node *html.Node
+	doc  *goquery.Document
+	// 延迟解码的 JSON, 参考 JSON
+	json       interface{}
+	jsonErr    error
+	jsonParsed bool
+}
+
+// AbsUrl 将 ref 转换为相对于 BaseUrl 的绝对地址, 失败时返回 ref
+func (c *Context) AbsUrl(ref string) string {
+	if c.Resp == nil {
+		return ref
+	}
+	base, err := url.Parse(c.BaseUrl())
+	if err != nil {
+		return ref
+	}
+	u, err := base.Parse(ref)
+	if err != nil {
+		return ref
+	}
+	return u.String()
 }
 
 func (c *Context) ParseJSReg(name string, reg string) ParseResult {
@@ -41,15 +141,17 @@ func (c *Context) ParseJSReg(name string, reg string) ParseResult {
 	result := ParseResult{}
 
 	for _, m := range matches {
-		u := string(m[1])
-		result.Requesrts = append(
-			result.Requesrts, &Request{
-				Method:   "GET",
-				Task:     c.Req.Task,
-				Url:      u,
-				Depth:    c.Req.Depth + 1,
-				RuleName: name,
-			})
+		u := c.AbsUrl(string(m[1]))
+		result.Requesrts = append(result.Requesrts, c.Req.Child(u, name))
+	}
+	return result
+}
+
+// ParseJSCSS 用于动态规则, 将匹配 CSS 选择器的元素的 href 作为 name 规则的请求
+func (c *Context) ParseJSCSS(name string, selector string) ParseResult {
+	result := ParseResult{}
+	for _, href := range c.CSSAttrs(selector, "href") {
+		result.Requesrts = append(result.Requesrts, c.Req.Child(c.AbsUrl(href), name))
 	}
 	return result
 }
@@ -59,15 +161,32 @@ func (c *Context) OutputJS(reg string) ParseResult {
 	ok := re.Match(c.Body)
 	if !ok {
 		return ParseResult{
-			Items: []interface{}{},
+			Items: []*DataCell{},
 		}
 	}
 	result := ParseResult{
-		Items: []interface{}{c.Req.Url},
+		Items: []*DataCell{c.Output(map[string]interface{}{"url": c.Req.Url})},
 	}
 	return result
 }
 
+// OutputItemJS 用于动态规则输出一条结构化数据
+func (c *Context) OutputItemJS(data map[string]interface{}) ParseResult {
+	return ParseResult{
+		Items: []*DataCell{c.Output(data)},
+	}
+}
+
+// Output 生成一条由当前请求的规则输出的数据
+func (c *Context) Output(data map[string]interface{}) *DataCell {
+	return &DataCell{
+		Task: c.Req.Task,
+		Req:  c.Req,
+		Rule: c.Req.RuleName,
+		Data: data,
+	}
+}
+
 // 单个请求
 type Request struct {
 	Task     *Task
@@ -76,14 +195,163 @@ type Request struct {
 	Priority int64
 	Depth    int64
 	RuleName string
+	Attempt  int // 已经请求失败的次数
 
-	unique string
+	Header http.Header // 请求头, 会覆盖抓取器默认设置的同名请求头
+	Query  url.Values  // 追加到 Url 上的查询参数
+	// 请求体, 按 Form、JSON、Body 的优先级使用其中一个
+	Form url.Values  // 表单, 以 application/x-www-form-urlencoded 编码
+	JSON interface{} // 以 application/json 编码
+	Body []byte      // 原始请求体
+	// 传递给子请求的任意数据
+	Meta map[string]interface{}
+	// 请求使用的会话, 由 Sessions 中间件设置
+	Session *Session
+
+	ctx context.Context
 }
 
-// 请求的唯一识别码
+// Context 请求的 context, 未设置时为 context.Background
+func (r *Request) Context() context.Context {
+	if r.ctx != nil {
+		return r.ctx
+	}
+	return context.Background()
+}
+
+// WithContext 返回使用 ctx 的浅拷贝, ctx 取消时正在进行的请求会被中止
+func (r *Request) WithContext(ctx context.Context) *Request {
+	r2 := *r
+	r2.ctx = ctx
+	return &r2
+}
+
+// Child 创建当前请求的子请求, 子请求会继承任务和 Meta
+func (r *Request) Child(url string, ruleName string) *Request {
+	var meta map[string]interface{}
+	if r.Meta != nil {
+		meta = make(map[string]interface{}, len(r.Meta))
+		for k, v := range r.Meta {
+			meta[k] = v
+		}
+	}
+	return &Request{
+		Task:     r.Task,
+		Url:      url,
+		Method:   "GET",
+		Depth:    r.Depth + 1,
+		RuleName: ruleName,
+		Meta:     meta,
+	}
+}
+
+// FullUrl 追加了 Query 参数的 URL
+func (r *Request) FullUrl() string {
+	if len(r.Query) == 0 {
+		return r.Url
+	}
+	u, err := url.Parse(r.Url)
+	if err != nil {
+		return r.Url
+	}
+	q := u.Query()
+	for k, vs := range r.Query {
+		for _, v := range vs {
+			q.Add(k, v)
+		}
+	}
+	u.RawQuery = q.Encode()
+	return u.String()
+}
+
+// Payload 返回编码后的请求体和对应的 Content-Type
+func (r *Request) Payload() ([]byte, string, error) {
+	switch {
+	case r.Form != nil:
+		return []byte(r.Form.Encode()), "application/x-www-form-urlencoded", nil
+	case r.JSON != nil:
+		b, err := json.Marshal(r.JSON)
+		if err != nil {
+			return nil, "", errors.Wrap(err, "encode json body error")
+		}
+		return b, "application/json", nil
+	}
+	return r.Body, "", nil
+}
+
+// HTTPRequest 根据请求的方法、URL、请求头和请求体创建 http.Request
+func (r *Request) HTTPRequest() (*http.Request, error) {
+	body, contentType, err := r.Payload()
+	if err != nil {
+		return nil, err
+	}
+	var reader io.Reader
+	if len(body) > 0 {
+		reader = bytes.NewReader(body)
+	}
+	req, err := http.NewRequestWithContext(r.Context(), r.method(), r.FullUrl(), reader)
+	if err != nil {
+		return nil, err
+	}
+	if contentType != "" {
+		req.Header.Set("Content-Type", contentType)
+	}
+	for k, vs := range r.Header {
+		req.Header.Del(k)
+		for _, v := range vs {
+			req.Header.Add(k, v)
+		}
+	}
+	return req, nil
+}
+
+// Normalizer 请求所属任务的 URL 规范化规则
+func (r *Request) Normalizer() *Normalizer {
+	if r.Task != nil && r.Task.Normalizer != nil {
+		return r.Task.Normalizer
+	}
+	return DefaultNormalizer
+}
+
+// Normalize 将请求的 Url 替换为规范化后的 URL, Method 转为大写
+func (r *Request) Normalize() error {
+	u, err := r.Normalizer().Normalize(r.Url)
+	if err != nil {
+		return err
+	}
+	r.Url = u
+	r.Method = r.method()
+	return nil
+}
+
+func (r *Request) method() string {
+	if r.Method == "" {
+		return "GET"
+	}
+	return strings.ToUpper(r.Method)
+}
+
+// 请求的唯一识别码, 由请求方法、规范化后的 URL 和请求体的哈希组成
 func (r *Request) Unique() string {
-	block := md5.Sum([]byte(r.Url + r.Method))
-	return hex.EncodeToString(block[:])
+	u, err := r.Normalizer().Normalize(r.FullUrl())
+	if err != nil {
+		u = r.FullUrl()
+	}
+	h := md5.New()
+	h.Write([]byte(r.method() + " " + u + " "))
+	if body, _, err := r.Payload(); err == nil && len(body) > 0 {
+		sum := md5.Sum(body)
+		h.Write(sum[:])
+	}
+	return hex.EncodeToString(h.Sum(nil))
+}
+
+// RetryPolicy 请求所属任务的重试策略
+func (r *Request) RetryPolicy() *RetryPolicy {
+	if r.Task != nil && r.Task.Retry != nil {
+		return r.Task.Retry
+	}
+	return DefaultRetryPolicy
 }
 
 func (r *Request) Check() error {
@@ -95,5 +363,5 @@ func (r *Request) Check() error {
 
 type ParseResult struct {
 	Requesrts []*Request
-	Items     []interface{}
+	Items     []*DataCell
 }
@@ -0,0 +1,50 @@
+package collect_test
+
+import (
+	"github.com/funbinary/crawler/collect"
+	"github.com/stretchr/testify/require"
+	"io"
+	"net/http"
+	"net/url"
+	"testing"
+)
+
+func TestHTTPRequest(t *testing.T) {
+	r := &collect.Request{
+		Url:    "https://douban.com/search?cat=1",
+		Method: "post",
+		Header: http.Header{"X-Token": {"abc"}},
+		Query:  url.Values{"page": {"2"}},
+		Form:   url.Values{"q": {"阳台"}},
+	}
+	req, err := r.HTTPRequest()
+	require.NoError(t, err)
+	require.Equal(t, "POST", req.Method)
+	require.Equal(t, "https://douban.com/search?cat=1&page=2", req.URL.String())
+	require.Equal(t, "abc", req.Header.Get("X-Token"))
+	require.Equal(t, "application/x-www-form-urlencoded", req.Header.Get("Content-Type"))
+	body, err := io.ReadAll(req.Body)
+	require.NoError(t, err)
+	require.Equal(t, "q=%E9%98%B3%E5%8F%B0", string(body))
+
+	r = &collect.Request{
+		Url:    "https://douban.com/api",
+		Method: "PUT",
+		JSON:   map[string]interface{}{"start": 25},
+	}
+	req, err = r.HTTPRequest()
+	require.NoError(t, err)
+	require.Equal(t, "application/json", req.Header.Get("Content-Type"))
+	body, err = io.ReadAll(req.Body)
+	require.NoError(t, err)
+	require.Equal(t, `{"start":25}`, string(body))
+}
+
+func TestChild(t *testing.T) {
+	parent := &collect.Request{Depth: 1, Meta: map[string]interface{}{"group": "szsh"}}
+	child := parent.Child("https://douban.com/topic/1/", "topic")
+	require.Equal(t, int64(2), child.Depth)
+	require.Equal(t, "szsh", child.Meta["group"])
+	child.Meta["title"] = "t"
+	require.NotContains(t, parent.Meta, "title")
+}
@@ -0,0 +1,107 @@
+package collect
+
+import (
+	"bufio"
+	"bytes"
+	"golang.org/x/text/transform"
+	"io"
+	"mime"
+	"net/http"
+	"net/url"
+	"strings"
+	"time"
+)
+
+// Response 抓取器返回的响应
+type Response struct {
+	Request     *Request
+	StatusCode  int
+	Header      http.Header
+	Url         string        // 重定向之后最终的 URL
+	ContentType string        // 不含参数的媒体类型, 如 text/html
+	Body        []byte        // 已经转换为 utf-8 的响应体
+	FetchTime   time.Time     // 开始请求的时间
+	Duration    time.Duration // 请求耗时
+	Cached      bool          // 是否来自缓存, 参考 Cache
+
+	// 以下字段用于保存原始的抓取记录, 参考 WARCWriter
+	Proto         string      // 协议版本, 如 HTTP/1.1
+	RawBody       []byte      // 转换编码之前的响应体
+	RequestHeader http.Header // 最后一次实际发送的请求头, 包含会话添加的 Cookie
+	Proxy         string      // 使用的代理, 没有使用代理时为空
+}
+
+// ReadResponse 读取 http 响应, 并将响应体转换为 utf-8
+func ReadResponse(request *Request, resp *http.Response, start time.Time) (*Response, error) {
+	raw, err := io.ReadAll(resp.Body)
+	if err != nil {
+		return nil, err
+	}
+	body, err := decodeBody(raw, resp.Header.Get("Content-Type"))
+	if err != nil {
+		return nil, err
+	}
+	response := &Response{
+		Request:    request,
+		StatusCode: resp.StatusCode,
+		Header:     resp.Header,
+		Url:        request.FullUrl(),
+		Body:       body,
+		FetchTime:  start,
+		Duration:   time.Since(start),
+		Proto:      resp.Proto,
+		RawBody:    raw,
+	}
+	if resp.Request != nil && resp.Request.URL != nil {
+		response.Url = resp.Request.URL.String()
+		response.RequestHeader = resp.Request.Header
+	}
+	response.ContentType = mediaType(resp.Header.Get("Content-Type"))
+	return response, nil
+}
+
+// mediaType 不含参数的媒体类型, 解析失败时返回空字符串
+func mediaType(contentType string) string {
+	t, _, err := mime.ParseMediaType(contentType)
+	if err != nil {
+		return ""
+	}
+	return t
+}
+
+// decodeBody 根据 Content-Type 和内容判断编码, 将响应体转换为 utf-8
+func decodeBody(raw []byte, contentType string) ([]byte, error) {
+	r := bufio.NewReader(bytes.NewReader(raw))
+	e := DeterminEncodingWithType(r, contentType)
+	return io.ReadAll(transform.NewReader(r, e.NewDecoder()))
+}
+
+// ResolveUrl 将 ref 转换为相对于最终 URL 的绝对地址
+func (r *Response) ResolveUrl(ref string) (string, error) {
+	base, err := url.Parse(r.Url)
+	if err != nil {
+		return "", err
+	}
+	u, err := base.Parse(ref)
+	if err != nil {
+		return "", err
+	}
+	return u.String(), nil
+}
+
+// LastModified 响应头中的 Last-Modified, 不存在时返回零值
+func (r *Response) LastModified() time.Time {
+	t, err := http.ParseTime(r.Header.Get("Last-Modified"))
+	if err != nil {
+		return time.Time{}
+	}
+	return t
+}
+
+func (r *Response) IsHTML() bool {
+	return r.ContentType == "text/html" || r.ContentType == "application/xhtml+xml"
+}
+
+func (r *Response) IsJSON() bool {
+	return r.ContentType == "application/json" || strings.HasSuffix(r.ContentType, "+json")
+}
@@ -0,0 +1,132 @@
+package collect
+
+import (
+	"context"
+	"errors"
+	"math"
+	"math/rand"
+	"net"
+	"time"
+)
+
+// ErrorClass 请求失败的原因分类
+type ErrorClass string
+
+const (
+	ErrorTimeout   ErrorClass = "timeout"   // 连接或读取超时
+	ErrorNetwork   ErrorClass = "network"   // 其它网络错误
+	ErrorStatus    ErrorClass = "status"    // 服务器返回非预期的状态码
+	ErrorContent   ErrorClass = "content"   // 返回的内容未通过校验
+	ErrorBlocked   ErrorClass = "blocked"   // 返回的是网站的封禁页面
+	ErrorSession   ErrorClass = "session"   // 会话过期, 需要重新登录
+	ErrorForbidden ErrorClass = "forbidden" // 禁止访问的地址, 不会重试
+)
+
+// ErrInvalidContent 返回的内容未通过校验
+var ErrInvalidContent = errors.New("invalid content")
+
+// ClassifyError 判断失败的原因
+func ClassifyError(err error) ErrorClass {
+	var statusErr *StatusError
+	if errors.As(err, &statusErr) {
+		return ErrorStatus
+	}
+	if errors.Is(err, ErrBlocked) {
+		return ErrorBlocked
+	}
+	if errors.Is(err, ErrForbiddenAddress) {
+		return ErrorForbidden
+	}
+	if errors.Is(err, ErrSessionExpired) {
+		return ErrorSession
+	}
+	if errors.Is(err, ErrInvalidContent) {
+		return ErrorContent
+	}
+	if errors.Is(err, context.DeadlineExceeded) {
+		return ErrorTimeout
+	}
+	var netErr net.Error
+	if errors.As(err, &netErr) && netErr.Timeout() {
+		return ErrorTimeout
+	}
+	return ErrorNetwork
+}
+
+// RetryPolicy 请求失败后的重试策略
+type RetryPolicy struct {
+	MaxAttempts  int           // 最多请求次数, 包含首次请求
+	BaseDelay    time.Duration // 首次重试前的等待时间, 之后每次翻倍
+	MaxDelay     time.Duration // 等待时间的上限
+	Jitter       float64       // 等待时间随机浮动的比例, 取值 0~1
+	RetryClasses []ErrorClass  // 可以重试的失败原因, 为空时都可以重试
+	RetryStatus  []int         // 可以重试的状态码, 为空时使用 DefaultRetryStatus
+}
+
+// 默认失败后重试一次
+var DefaultRetryPolicy = &RetryPolicy{
+	MaxAttempts: 2,
+	BaseDelay:   time.Second,
+	MaxDelay:    30 * time.Second,
+	Jitter:      0.2,
+}
+
+// 默认可以重试的状态码
+var DefaultRetryStatus = []int{408, 429, 500, 502, 503, 504}
+
+// ShouldRetry 第 attempt 次请求因 err 失败后是否应该重试
+func (p *RetryPolicy) ShouldRetry(attempt int, err error) bool {
+	if attempt >= p.MaxAttempts {
+		return false
+	}
+	class := ClassifyError(err)
+	if class == ErrorForbidden {
+		return false
+	}
+	if len(p.RetryClasses) > 0 && !containsClass(p.RetryClasses, class) {
+		return false
+	}
+	if class == ErrorStatus {
+		var statusErr *StatusError
+		errors.As(err, &statusErr)
+		status := p.RetryStatus
+		if len(status) == 0 {
+			status = DefaultRetryStatus
+		}
+		return containsInt(status, statusErr.Code)
+	}
+	return true
+}
+
+// Backoff 第 attempt 次请求失败后, 重试前需要等待的时间
+func (p *RetryPolicy) Backoff(attempt int) time.Duration {
+	if attempt < 1 {
+		attempt = 1
+	}
+	d := float64(p.BaseDelay) * math.Pow(2, float64(attempt-1))
+	if p.MaxDelay > 0 && d > float64(p.MaxDelay) {
+		d = float64(p.MaxDelay)
+	}
+	if p.Jitter > 0 {
+		d += d * p.Jitter * (2*rand.Float64() - 1)
+	}
+	return time.Duration(d)
+}
+
+func containsClass(classes []ErrorClass, class ErrorClass) bool {
+	for _, c := range classes {
+		if c == class {
+			return true
+		}
+	}
+	return false
+}
+
+func containsInt(list []int, v int) bool {
+	for _, i := range list {
+		if i == v {
+			return true
+		}
+	}
+	return false
+}
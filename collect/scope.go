@@ -0,0 +1,31 @@
+package collect
+
+import (
+	"net/url"
+	"strings"
+)
+
+// InScope 判断 URL 是否在任务的抓取范围内, 即域名在 AllowedDomains 中, 匹配 Include 且不匹配 Exclude
+func (p *Property) InScope(rawurl string) bool {
+	if len(p.AllowedDomains) > 0 {
+		u, err := url.Parse(rawurl)
+		if err != nil || !matchDomain(strings.ToLower(u.Hostname()), p.AllowedDomains) {
+			return false
+		}
+	}
+	b := []byte(rawurl)
+	for _, pattern := range p.Exclude {
+		if match(pattern, b) {
+			return false
+		}
+	}
+	if len(p.Include) == 0 {
+		return true
+	}
+	for _, pattern := range p.Include {
+		if match(pattern, b) {
+			return true
+		}
+	}
+	return false
+}
@@ -0,0 +1,149 @@
+package collect
+
+import (
+	"bytes"
+	"github.com/PuerkitoBio/goquery"
+	"github.com/antchfx/htmlquery"
+	"golang.org/x/net/html"
+	"strings"
+)
+
+// 以下方法在第一次使用时解析响应体, CSS 选择器和 XPath 共用解析结果。
+// 动态规则中可以通过 ctx 直接调用, 如 ctx.CSSTexts("a.title")
+
+// Node 解析后的 HTML 文档的根节点, 解析失败时返回空文档
+func (c *Context) Node() *html.Node {
+	if c.node == nil {
+		node, err := html.Parse(bytes.NewReader(c.Body))
+		if err != nil {
+			node = &html.Node{Type: html.DocumentNode}
+		}
+		c.node = node
+	}
+	return c.node
+}
+
+// Document 用于 CSS 选择器查询的文档
+func (c *Context) Document() *goquery.Document {
+	if c.doc == nil {
+		c.doc = goquery.NewDocumentFromNode(c.Node())
+	}
+	return c.doc
+}
+
+// Find 使用 CSS 选择器查询, 选择器不合法时没有匹配结果
+func (c *Context) Find(selector string) *goquery.Selection {
+	return c.Document().Find(selector)
+}
+
+// CSSText 第一个匹配元素去掉首尾空白后的文本
+func (c *Context) CSSText(selector string) string {
+	return strings.TrimSpace(c.Find(selector).First().Text())
+}
+
+// CSSTexts 所有匹配元素去掉首尾空白后的文本
+func (c *Context) CSSTexts(selector string) []string {
+	return c.Find(selector).Map(func(_ int, s *goquery.Selection) string {
+		return strings.TrimSpace(s.Text())
+	})
+}
+
+// CSSAttr 第一个匹配元素的属性, 不存在时返回空字符串
+func (c *Context) CSSAttr(selector string, attr string) string {
+	return c.Find(selector).First().AttrOr(attr, "")
+}
+
+// CSSAttrs 所有匹配且包含该属性的元素的属性值
+func (c *Context) CSSAttrs(selector string, attr string) []string {
+	var attrs []string
+	c.Find(selector).Each(func(_ int, s *goquery.Selection) {
+		if v, ok := s.Attr(attr); ok {
+			attrs = append(attrs, v)
+		}
+	})
+	return attrs
+}
+
+// CSSHTML 第一个匹配元素的内部 HTML
+func (c *Context) CSSHTML(selector string) string {
+	h, _ := c.Find(selector).First().Html()
+	return h
+}
+
+// CSSHTMLs 所有匹配元素的内部 HTML
+func (c *Context) CSSHTMLs(selector string) []string {
+	return c.Find(selector).Map(func(_ int, s *goquery.Selection) string {
+		h, _ := s.Html()
+		return h
+	})
+}
+
+// XPath 使用 XPath 表达式查询, 返回匹配的节点
+func (c *Context) XPath(expr string) ([]*html.Node, error) {
+	return htmlquery.QueryAll(c.Node(), expr)
+}
+
+// xpath 表达式不合法时没有匹配结果
+func (c *Context) xpath(expr string) []*html.Node {
+	nodes, _ := c.XPath(expr)
+	return nodes
+}
+
+// XPathText 第一个匹配节点去掉首尾空白后的文本, 属性节点返回属性值, 如 //a/@href
+func (c *Context) XPathText(expr string) string {
+	nodes := c.xpath(expr)
+	if len(nodes) == 0 {
+		return ""
+	}
+	return strings.TrimSpace(htmlquery.InnerText(nodes[0]))
+}
+
+// XPathTexts 所有匹配节点去掉首尾空白后的文本
+func (c *Context) XPathTexts(expr string) []string {
+	var texts []string
+	for _, n := range c.xpath(expr) {
+		texts = append(texts, strings.TrimSpace(htmlquery.InnerText(n)))
+	}
+	return texts
+}
+
+// XPathAttr 第一个匹配元素的属性, 不存在时返回空字符串
+func (c *Context) XPathAttr(expr string, attr string) string {
+	nodes := c.xpath(expr)
+	if len(nodes) == 0 {
+		return ""
+	}
+	return htmlquery.SelectAttr(nodes[0], attr)
+}
+
+// XPathAttrs 所有匹配且包含该属性的元素的属性值
+func (c *Context) XPathAttrs(expr string, attr string) []string {
+	var attrs []string
+	for _, n := range c.xpath(expr) {
+		for _, a := range n.Attr {
+			if a.Key == attr {
+				attrs = append(attrs, a.Val)
+				break
+			}
+		}
+	}
+	return attrs
+}
+
+// XPathHTML 第一个匹配元素的内部 HTML
+func (c *Context) XPathHTML(expr string) string {
+	nodes := c.xpath(expr)
+	if len(nodes) == 0 {
+		return ""
+	}
+	return htmlquery.OutputHTML(nodes[0], false)
+}
+
+// XPathHTMLs 所有匹配元素的内部 HTML
+func (c *Context) XPathHTMLs(expr string) []string {
+	var htmls []string
+	for _, n := range c.xpath(expr) {
+		htmls = append(htmls, htmlquery.OutputHTML(n, false))
+	}
+	return htmls
+}
@@ -0,0 +1,47 @@
+package collect_test
+
+import (
+	"github.com/funbinary/crawler/collect"
+	"github.com/stretchr/testify/require"
+	"testing"
+)
+
+const selectorPage = `<html><body>
+<table class="olt">
+  <tr><td class="title"><a href="/group/topic/1/" title="带阳台的房间">带阳台...</a></td></tr>
+  <tr><td class="title"><a href="https://www.douban.com/group/topic/2/"> 次卧 </a></td></tr>
+</table>
+<div class="topic-content"><p>朝南 <b>阳台</b></p></div>
+</body></html>`
+
+func TestContextSelector(t *testing.T) {
+	req := &collect.Request{Url: "https://www.douban.com/group/szsh/discussion"}
+	ctx := &collect.Context{
+		Body: []byte(selectorPage),
+		Req:  req,
+		Resp: &collect.Response{Request: req, Url: req.Url},
+	}
+
+	require.Equal(t, "带阳台...", ctx.CSSText("td.title a"))
+	require.Equal(t, []string{"带阳台...", "次卧"}, ctx.CSSTexts("td.title a"))
+	require.Equal(t, "带阳台的房间", ctx.CSSAttr("td.title a", "title"))
+	require.Equal(t, []string{"带阳台的房间"}, ctx.CSSAttrs("td.title a", "title"))
+	require.Equal(t, "<p>朝南 <b>阳台</b></p>", ctx.CSSHTML("div.topic-content"))
+	require.Empty(t, ctx.CSSText("div.missing"))
+	require.Empty(t, ctx.CSSTexts("[invalid"))
+
+	require.Equal(t, "带阳台...", ctx.XPathText(`//td[@class="title"]/a`))
+	require.Equal(t, []string{"/group/topic/1/", "https://www.douban.com/group/topic/2/"}, ctx.XPathTexts(`//td[@class="title"]/a/@href`))
+	require.Equal(t, "带阳台的房间", ctx.XPathAttr(`//td[@class="title"]/a`, "title"))
+	require.Equal(t, []string{"带阳台的房间"}, ctx.XPathAttrs(`//td[@class="title"]/a`, "title"))
+	require.Equal(t, "<p>朝南 <b>阳台</b></p>", ctx.XPathHTML(`//div[@class="topic-content"]`))
+	require.Equal(t, []string{"阳台"}, ctx.XPathHTMLs(`//b`))
+	_, err := ctx.XPath("//[")
+	require.Error(t, err)
+	require.Empty(t, ctx.XPathTexts("//["))
+
+	result := ctx.ParseJSCSS("detail", "td.title a")
+	require.Len(t, result.Requesrts, 2)
+	require.Equal(t, "https://www.douban.com/group/topic/1/", result.Requesrts[0].Url)
+	require.Equal(t, "detail", result.Requesrts[0].RuleName)
+}
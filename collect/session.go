@@ -0,0 +1,331 @@
+package collect
+
+import (
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net/http"
+	"net/http/cookiejar"
+	"net/url"
+	"os"
+	"path/filepath"
+	"strings"
+	"sync"
+	"sync/atomic"
+	"time"
+)
+
+// ErrSessionExpired 会话已经过期, 如被重定向到登录页面
+var ErrSessionExpired = errors.New("session expired")
+
+// SessionConfig 任务的会话配置
+type SessionConfig struct {
+	Names    []string `json:"names"`     // 会话名, 每个会话有独立的 Cookie, 为空时使用一个名为 default 的会话
+	StoreDir string   `json:"store_dir"` // 保存 Cookie 的目录, 为空时只保存在内存中
+	LoginUrl string   `json:"login_url"` // 正则表达式, 响应的最终 URL 匹配时认为会话已经过期
+}
+
+// Session 一个独立的会话, Cookie 会在请求之间保持, 并根据响应中的 Set-Cookie 更新
+type Session struct {
+	Name    string
+	jar     *cookiejar.Jar
+	mu      sync.Mutex
+	cookies map[string]map[string]*http.Cookie // host -> 名称 -> Cookie, 用于持久化
+	seeded  map[string]bool                    // 已经设置过初始 Cookie 的 host
+	initial []*http.Cookie                     // 任务配置的初始 Cookie
+	digest  string                             // 初始 Cookie 的摘要, 用于判断保存的 Cookie 是否仍然可用
+	expired atomic.Bool
+	dirty   atomic.Bool
+
+	loginLock sync.Mutex
+	loggedIn  bool
+}
+
+func newSession(name string, initial []*http.Cookie) *Session {
+	jar, _ := cookiejar.New(nil)
+	return &Session{
+		Name:    name,
+		jar:     jar,
+		cookies: make(map[string]map[string]*http.Cookie),
+		seeded:  make(map[string]bool),
+		initial: initial,
+		digest:  cookieDigest(initial),
+	}
+}
+
+// cookieDigest 初始 Cookie 的摘要, 没有初始 Cookie 时为空
+func cookieDigest(cookies []*http.Cookie) string {
+	if len(cookies) == 0 {
+		return ""
+	}
+	h := sha256.New()
+	for _, c := range cookies {
+		h.Write([]byte(c.Name + "=" + c.Value + ";"))
+	}
+	return hex.EncodeToString(h.Sum(nil))
+}
+
+// SetCookies 实现 http.CookieJar
+func (s *Session) SetCookies(u *url.URL, cookies []*http.Cookie) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	// Reset 会替换 jar, 因此只能在持有锁时访问
+	s.jar.SetCookies(u, cookies)
+	host := u.Hostname()
+	if s.cookies[host] == nil {
+		s.cookies[host] = make(map[string]*http.Cookie)
+	}
+	for _, c := range cookies {
+		s.cookies[host][c.Name] = c
+	}
+	s.dirty.Store(true)
+}
+
+// Cookies 实现 http.CookieJar, 第一次访问某个 host 时先设置任务配置的初始 Cookie。
+// 从文件中恢复了该 host 的 Cookie 时不再设置, 避免覆盖服务器更新过的值
+func (s *Session) Cookies(u *url.URL) []*http.Cookie {
+	s.mu.Lock()
+	host := u.Hostname()
+	seed := !s.seeded[host] && len(s.initial) > 0 && len(s.cookies[host]) == 0
+	s.seeded[host] = true
+	s.mu.Unlock()
+	if seed {
+		// 与服务器设置的 Cookie 一起保存
+		s.SetCookies(u, s.initial)
+	}
+	s.mu.Lock()
+	jar := s.jar
+	s.mu.Unlock()
+	return jar.Cookies(u)
+}
+
+// Expired 会话是否已经过期
+func (s *Session) Expired() bool {
+	return s.expired.Load()
+}
+
+// Expire 标记会话过期, 过期的会话不会再分配给请求
+func (s *Session) Expire() {
+	s.expired.Store(true)
+}
+
+// Reset 清空会话的 Cookie 并标记为有效, 用于重新登录
+func (s *Session) Reset() {
+	jar, _ := cookiejar.New(nil)
+	s.mu.Lock()
+	s.jar = jar
+	s.cookies = make(map[string]map[string]*http.Cookie)
+	s.seeded = make(map[string]bool)
+	s.mu.Unlock()
+	s.expired.Store(false)
+	s.dirty.Store(true)
+}
+
+// ensureLogin 会话尚未登录或已经过期时, 清空 Cookie 后执行 login, 同一会话同时只有一个登录流程。
+// 返回的 bool 表示本次是否执行了 login
+func (s *Session) ensureLogin(login func(s *Session) error) (bool, error) {
+	s.loginLock.Lock()
+	defer s.loginLock.Unlock()
+	if s.loggedIn && !s.Expired() {
+		return false, nil
+	}
+	s.Reset()
+	if err := login(s); err != nil {
+		s.Expire()
+		return true, err
+	}
+	s.loggedIn = true
+	return true, nil
+}
+
+type sessionRecord struct {
+	Expired bool                      `json:"expired"`
+	Initial string                    `json:"initial,omitempty"` // 保存时任务配置的初始 Cookie 的摘要
+	Cookies map[string][]*http.Cookie `json:"cookies"`           // host -> Cookie
+}
+
+func (s *Session) record() sessionRecord {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	r := sessionRecord{Expired: s.Expired(), Initial: s.digest, Cookies: make(map[string][]*http.Cookie)}
+	now := time.Now()
+	for host, cookies := range s.cookies {
+		for _, c := range cookies {
+			if !c.Expires.IsZero() && c.Expires.Before(now) || c.MaxAge < 0 {
+				continue
+			}
+			r.Cookies[host] = append(r.Cookies[host], c)
+		}
+	}
+	return r
+}
+
+// restore 恢复保存的 Cookie。已经过期的会话或任务配置的 Cookie 发生变化时丢弃保存的 Cookie,
+// 会话重新从配置的 Cookie 开始, 不会一直处于过期状态
+func (s *Session) restore(r sessionRecord) {
+	if r.Expired || r.Initial != s.digest {
+		s.dirty.Store(true)
+		return
+	}
+	for host, cookies := range r.Cookies {
+		for _, scheme := range []string{"http", "https"} {
+			s.SetCookies(&url.URL{Scheme: scheme, Host: host, Path: "/"}, cookies)
+		}
+	}
+	s.dirty.Store(false)
+	// 恢复了有效的 Cookie 时不需要重新登录
+	s.loggedIn = len(r.Cookies) > 0
+}
+
+// SessionPool 任务的所有会话, 轮流分配给请求
+type SessionPool struct {
+	sessions []*Session
+	index    atomic.Uint32
+	relogin  bool   // 过期的会话是否可以重新登录
+	path     string // 保存 Cookie 的文件
+	saveLock sync.Mutex
+	lastSave time.Time
+}
+
+// 两次保存 Cookie 之间的最小间隔
+const sessionSaveInterval = 5 * time.Second
+
+// NewSessionPool 根据配置创建会话, 配置了 StoreDir 时从文件中恢复之前保存的 Cookie
+func NewSessionPool(taskName string, cfg SessionConfig, initial []*http.Cookie) (*SessionPool, error) {
+	names := cfg.Names
+	if len(names) == 0 {
+		names = []string{"default"}
+	}
+	p := &SessionPool{}
+	for _, name := range names {
+		p.sessions = append(p.sessions, newSession(name, initial))
+	}
+	if cfg.StoreDir == "" {
+		return p, nil
+	}
+	if err := os.MkdirAll(cfg.StoreDir, 0700); err != nil {
+		return nil, err
+	}
+	p.path = filepath.Join(cfg.StoreDir, taskName+".cookies.json")
+	b, err := os.ReadFile(p.path)
+	if os.IsNotExist(err) {
+		return p, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+	records := make(map[string]sessionRecord)
+	if err := json.Unmarshal(b, &records); err != nil {
+		return nil, fmt.Errorf("decode cookie file %s error: %w", p.path, err)
+	}
+	for _, s := range p.sessions {
+		if r, ok := records[s.Name]; ok {
+			s.restore(r)
+		}
+	}
+	return p, nil
+}
+
+// Next 返回下一个可用的会话, 所有会话都过期且不能重新登录时返回 nil
+func (p *SessionPool) Next() *Session {
+	n := uint32(len(p.sessions))
+	for i := uint32(0); i < n; i++ {
+		s := p.sessions[(p.index.Add(1)-1)%n]
+		if p.relogin || !s.Expired() {
+			return s
+		}
+	}
+	return nil
+}
+
+// Sessions 返回所有会话
+func (p *SessionPool) Sessions() []*Session {
+	return p.sessions
+}
+
+// Save 将所有会话的 Cookie 保存到文件, force 为 false 时只在有变化且距上次保存超过一定时间时保存
+func (p *SessionPool) Save(force bool) error {
+	if p.path == "" {
+		return nil
+	}
+	p.saveLock.Lock()
+	defer p.saveLock.Unlock()
+	dirty := false
+	for _, s := range p.sessions {
+		if s.dirty.Load() {
+			dirty = true
+		}
+	}
+	if !force && (!dirty || time.Since(p.lastSave) < sessionSaveInterval) {
+		return nil
+	}
+	records := make(map[string]sessionRecord)
+	for _, s := range p.sessions {
+		s.dirty.Store(false)
+		records[s.Name] = s.record()
+	}
+	b, err := json.MarshalIndent(records, "", "  ")
+	if err != nil {
+		return err
+	}
+	tmp := p.path + ".tmp"
+	if err := os.WriteFile(tmp, b, 0600); err != nil {
+		return err
+	}
+	p.lastSave = time.Now()
+	return os.Rename(tmp, p.path)
+}
+
+// ParseCookies 解析 Cookie 请求头格式的字符串, 如 "a=1; b=2"
+func ParseCookies(raw string) []*http.Cookie {
+	header := http.Header{"Cookie": {strings.TrimSpace(raw)}}
+	return (&http.Request{Header: header}).Cookies()
+}
+
+// Sessions 为请求分配任务的会话, 请求之间保持 Cookie, 并在跳转到登录页面时标记会话过期。
+// 任务配置了 Login 时, 会话在第一次使用前和过期后会先执行登录流程。
+// 需要配合 BrowserFetch 使用, BrowserFetch 会使用会话的 Cookie 并在重定向时更新。
+func Sessions() Middleware {
+	return func(next Fetcher) Fetcher {
+		return FetcherFunc(func(req *Request) (*Response, error) {
+			if req.Task == nil || req.Session != nil {
+				return next.Get(req)
+			}
+			pool, err := req.Task.SessionPool()
+			if err != nil {
+				return nil, err
+			}
+			session := pool.Next()
+			if session == nil {
+				return nil, fmt.Errorf("%w: all sessions of task %s expired", ErrSessionExpired, req.Task.Name)
+			}
+			if login := req.Task.Login; login != nil {
+				ran, err := session.ensureLogin(func(s *Session) error {
+					return login(&LoginContext{Task: req.Task, Session: s, Fetcher: next})
+				})
+				// 登录后立即保存新的 Cookie
+				if ran {
+					if serr := pool.Save(true); serr != nil && err == nil {
+						err = serr
+					}
+				}
+				if err != nil {
+					return nil, fmt.Errorf("%w: login session %s error: %v", ErrSessionExpired, session.Name, err)
+				}
+			}
+			r := *req
+			r.Session = session
+			resp, err := next.Get(&r)
+			if err == nil && req.Task.Session.LoginUrl != "" && match(req.Task.Session.LoginUrl, []byte(resp.Url)) {
+				session.Expire()
+				err = fmt.Errorf("%w: session %s redirected to %s", ErrSessionExpired, session.Name, resp.Url)
+			}
+			if serr := pool.Save(session.Expired()); serr != nil && err == nil {
+				err = serr
+			}
+			return resp, err
+		})
+	}
+}
@@ -0,0 +1,291 @@
+package collect_test
+
+import (
+	"errors"
+	"github.com/funbinary/crawler/collect"
+	"github.com/stretchr/testify/require"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"os"
+	"path/filepath"
+	"sort"
+	"strconv"
+	"sync"
+	"sync/atomic"
+	"testing"
+	"time"
+)
+
+func TestSessions(t *testing.T) {
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		switch r.URL.Path {
+		case "/login":
+			w.Write([]byte("login"))
+			return
+		case "/logout":
+			http.Redirect(w, r, "/login", http.StatusFound)
+			return
+		}
+		if _, err := r.Cookie("sid"); err != nil {
+			http.SetCookie(w, &http.Cookie{Name: "sid", Value: r.URL.Query().Get("id"), Path: "/"})
+		}
+		var got []string
+		for _, c := range r.Cookies() {
+			got = append(got, c.Name+"="+c.Value)
+		}
+		sort.Strings(got)
+		w.Header().Set("Content-Type", "text/plain")
+		for _, c := range got {
+			w.Write([]byte(c + ";"))
+		}
+	}))
+	defer server.Close()
+
+	dir := t.TempDir()
+	task := &collect.Task{Property: collect.Property{
+		Name:   "session",
+		Cookie: "bid=1",
+		Session: collect.SessionConfig{
+			Names:    []string{"a", "b"},
+			StoreDir: dir,
+			LoginUrl: "/login$",
+		},
+	}}
+	f := collect.Chain(&collect.BrowserFetch{Timeout: time.Second}, collect.Sessions(), collect.TaskCookie())
+	get := func(task *collect.Task, path string) (*collect.Response, error) {
+		return f.Get(&collect.Request{Task: task, Url: server.URL + path})
+	}
+
+	// 两个会话轮流使用, 各自保存服务器设置的 Cookie
+	resp, err := get(task, "/?id=a")
+	require.NoError(t, err)
+	require.Equal(t, "bid=1;", string(resp.Body))
+	_, err = get(task, "/?id=b")
+	require.NoError(t, err)
+	resp, err = get(task, "/")
+	require.NoError(t, err)
+	require.Equal(t, "bid=1;sid=a;", string(resp.Body))
+	resp, err = get(task, "/")
+	require.NoError(t, err)
+	require.Equal(t, "bid=1;sid=b;", string(resp.Body))
+
+	pool, err := task.SessionPool()
+	require.NoError(t, err)
+	require.NoError(t, pool.Save(true))
+
+	// 跳转到登录页面后会话过期, 之后只使用剩下的会话
+	_, err = get(task, "/logout")
+	require.True(t, errors.Is(err, collect.ErrSessionExpired))
+	require.Equal(t, collect.ErrorSession, collect.ClassifyError(err))
+	require.True(t, pool.Sessions()[0].Expired())
+	for i := 0; i < 2; i++ {
+		resp, err = get(task, "/")
+		require.NoError(t, err)
+		require.Equal(t, "bid=1;sid=b;", string(resp.Body))
+	}
+
+	require.NoError(t, pool.Save(true))
+
+	// 新的任务从文件中恢复 Cookie, 过期的会话丢弃保存的 Cookie 后重新使用
+	restored := &collect.Task{Property: task.Property}
+	pool, err = restored.SessionPool()
+	require.NoError(t, err)
+	require.False(t, pool.Sessions()[0].Expired())
+	resp, err = get(restored, "/")
+	require.NoError(t, err)
+	require.Equal(t, "bid=1;", string(resp.Body))
+	resp, err = get(restored, "/")
+	require.NoError(t, err)
+	require.Equal(t, "bid=1;sid=b;", string(resp.Body))
+}
+
+func TestCookieString(t *testing.T) {
+	t.Setenv("TEST_COOKIE", "")
+	task := &collect.Task{Property: collect.Property{Cookie: "a=1", CookieEnv: "TEST_COOKIE"}}
+	require.Equal(t, "a=1", task.CookieString())
+
+	t.Setenv("TEST_COOKIE", " a=2 ")
+	task = &collect.Task{Property: collect.Property{Cookie: "a=1", CookieEnv: "TEST_COOKIE"}}
+	require.Equal(t, "a=2", task.CookieString())
+}
+
+func TestSessionLogin(t *testing.T) {
+	var logins, valid atomic.Int64
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		switch r.URL.Path {
+		case "/login":
+			if r.Method != "POST" || r.FormValue("user") != "test" {
+				w.WriteHeader(http.StatusForbidden)
+				return
+			}
+			n := logins.Add(1)
+			valid.Store(n)
+			http.SetCookie(w, &http.Cookie{Name: "sid", Value: strconv.FormatInt(n, 10), Path: "/"})
+			w.Write([]byte("ok"))
+			return
+		}
+		c, err := r.Cookie("sid")
+		if err != nil || c.Value != strconv.FormatInt(valid.Load(), 10) {
+			w.Write([]byte(`<a class="login">登录</a>`))
+			return
+		}
+		w.Write([]byte("hello " + c.Value))
+	}))
+	defer server.Close()
+
+	task := &collect.Task{
+		Property: collect.Property{
+			Name:         "login",
+			ValidateRule: collect.ValidateRule{LoggedOutSelectors: []string{"a.login"}},
+		},
+		Login: func(ctx *collect.LoginContext) error {
+			_, err := ctx.PostForm(server.URL+"/login", url.Values{"user": {"test"}})
+			return err
+		},
+	}
+	f := collect.Chain(&collect.BrowserFetch{Timeout: time.Second}, collect.Sessions())
+	get := func() (*collect.Response, error) {
+		resp, err := f.Get(&collect.Request{Task: task, Url: server.URL + "/"})
+		if err != nil {
+			return nil, err
+		}
+		return resp, task.Validate(resp)
+	}
+
+	// 第一次使用会话前先登录
+	resp, err := get()
+	require.NoError(t, err)
+	require.Equal(t, "hello 1", string(resp.Body))
+	_, err = get()
+	require.NoError(t, err)
+	require.Equal(t, int64(1), logins.Load())
+
+	// 服务器使会话失效后, 校验器标记会话过期, 下次请求重新登录
+	valid.Store(0)
+	_, err = get()
+	require.Equal(t, collect.ErrorSession, collect.ClassifyError(err))
+	resp, err = get()
+	require.NoError(t, err)
+	require.Equal(t, "hello 2", string(resp.Body))
+
+	// 登录失败时返回会话过期的错误
+	task.Login = func(ctx *collect.LoginContext) error {
+		_, err := ctx.PostForm(server.URL+"/login", url.Values{"user": {"other"}})
+		return err
+	}
+	valid.Store(0)
+	_, err = get()
+	require.Error(t, err)
+	_, err = get()
+	require.True(t, errors.Is(err, collect.ErrSessionExpired))
+}
+
+func TestSessionResetConcurrent(t *testing.T) {
+	pool, err := collect.NewSessionPool("reset", collect.SessionConfig{}, nil)
+	require.NoError(t, err)
+	s := pool.Next()
+	u, _ := url.Parse("http://example.com/")
+
+	// Reset 与请求同时进行时不能出现数据竞争
+	var wg sync.WaitGroup
+	for i := 0; i < 4; i++ {
+		wg.Add(1)
+		go func(i int) {
+			defer wg.Done()
+			for j := 0; j < 100; j++ {
+				if i == 0 {
+					s.Reset()
+					continue
+				}
+				s.SetCookies(u, []*http.Cookie{{Name: "sid", Value: strconv.Itoa(j)}})
+				s.Cookies(u)
+			}
+		}(i)
+	}
+	wg.Wait()
+}
+
+func TestSessionLoginSave(t *testing.T) {
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.URL.Path == "/login" {
+			http.SetCookie(w, &http.Cookie{Name: "sid", Value: "1", Path: "/"})
+		}
+		w.Write([]byte("ok"))
+	}))
+	defer server.Close()
+
+	dir := t.TempDir()
+	task := &collect.Task{
+		Property: collect.Property{Name: "save", Session: collect.SessionConfig{StoreDir: dir}},
+		Login: func(ctx *collect.LoginContext) error {
+			_, err := ctx.Get(server.URL + "/login")
+			return err
+		},
+	}
+	f := collect.Chain(&collect.BrowserFetch{Timeout: time.Second}, collect.Sessions())
+	path := filepath.Join(dir, "save.cookies.json")
+
+	// 登录后立即保存 Cookie
+	_, err := f.Get(&collect.Request{Task: task, Url: server.URL + "/"})
+	require.NoError(t, err)
+	require.FileExists(t, path)
+
+	// 已经登录的会话不会在每次请求后都保存
+	require.NoError(t, os.Remove(path))
+	_, err = f.Get(&collect.Request{Task: task, Url: server.URL + "/"})
+	require.NoError(t, err)
+	require.NoFileExists(t, path)
+}
+
+func TestSessionRestoreCookie(t *testing.T) {
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.URL.Path == "/refresh" {
+			http.SetCookie(w, &http.Cookie{Name: "bid", Value: "2", Path: "/"})
+		}
+		if c, err := r.Cookie("bid"); err == nil {
+			w.Write([]byte(c.Value))
+		}
+	}))
+	defer server.Close()
+
+	dir := t.TempDir()
+	property := collect.Property{
+		Name:    "restore",
+		Cookie:  "bid=1",
+		Session: collect.SessionConfig{StoreDir: dir},
+	}
+	f := collect.Chain(&collect.BrowserFetch{Timeout: time.Second}, collect.Sessions())
+	get := func(task *collect.Task, path string) string {
+		resp, err := f.Get(&collect.Request{Task: task, Url: server.URL + path})
+		require.NoError(t, err)
+		return string(resp.Body)
+	}
+	restore := func(property collect.Property) *collect.Task {
+		task := &collect.Task{Property: property}
+		pool, err := task.SessionPool()
+		require.NoError(t, err)
+		require.NotNil(t, pool.Next())
+		return task
+	}
+
+	task := &collect.Task{Property: property}
+	require.Equal(t, "1", get(task, "/refresh"))
+	require.Equal(t, "2", get(task, "/"))
+	pool, err := task.SessionPool()
+	require.NoError(t, err)
+	require.NoError(t, pool.Save(true))
+
+	// 配置的 Cookie 不会覆盖服务器更新后保存的值
+	require.Equal(t, "2", get(restore(property), "/"))
+
+	// 配置的 Cookie 发生变化时不再使用保存的 Cookie
+	changed := property
+	changed.Cookie = "bid=3"
+	require.Equal(t, "3", get(restore(changed), "/"))
+
+	// 没有登录流程时, 过期的会话在重新启动后可以继续使用
+	pool.Sessions()[0].Expire()
+	require.NoError(t, pool.Save(true))
+	require.Equal(t, "1", get(restore(property), "/"))
+}
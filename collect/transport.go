@@ -0,0 +1,122 @@
+package collect
+
+import (
+	"context"
+	"crypto/tls"
+	"github.com/funbinary/crawler/proxy"
+	"net"
+	"net/http"
+	"net/url"
+	"time"
+)
+
+// TransportConfig 抓取器共享的连接池配置, 零值使用 DefaultTransportConfig 中对应的值
+type TransportConfig struct {
+	MaxIdleConns          int           // 所有站点的最大空闲连接数
+	MaxIdleConnsPerHost   int           // 每个站点的最大空闲连接数
+	MaxConnsPerHost       int           // 每个站点的最大连接数, 0 表示不限制
+	IdleConnTimeout       time.Duration // 空闲连接的超时时间
+	DialTimeout           time.Duration // 建立连接的超时时间
+	KeepAlive             time.Duration // TCP keep-alive 的间隔
+	TLSHandshakeTimeout   time.Duration
+	ResponseHeaderTimeout time.Duration // 等待响应头的超时时间, 0 表示只受请求超时限制
+	DisableKeepAlives     bool
+	DisableHTTP2          bool
+	TLSConfig             *tls.Config
+	Guard                 *NetworkGuard // 禁止访问内网地址, 为空时不限制
+}
+
+var DefaultTransportConfig = TransportConfig{
+	MaxIdleConns:        100,
+	MaxIdleConnsPerHost: 10,
+	IdleConnTimeout:     90 * time.Second,
+	DialTimeout:         30 * time.Second,
+	KeepAlive:           30 * time.Second,
+	TLSHandshakeTimeout: 10 * time.Second,
+}
+
+func (c TransportConfig) withDefaults() TransportConfig {
+	d := DefaultTransportConfig
+	if c.MaxIdleConns == 0 {
+		c.MaxIdleConns = d.MaxIdleConns
+	}
+	if c.MaxIdleConnsPerHost == 0 {
+		c.MaxIdleConnsPerHost = d.MaxIdleConnsPerHost
+	}
+	if c.IdleConnTimeout == 0 {
+		c.IdleConnTimeout = d.IdleConnTimeout
+	}
+	if c.DialTimeout == 0 {
+		c.DialTimeout = d.DialTimeout
+	}
+	if c.KeepAlive == 0 {
+		c.KeepAlive = d.KeepAlive
+	}
+	if c.TLSHandshakeTimeout == 0 {
+		c.TLSHandshakeTimeout = d.TLSHandshakeTimeout
+	}
+	return c
+}
+
+// NewTransport 根据配置创建独立的 http.Transport, 不会修改 http.DefaultTransport。
+// 同一个 Transport 会按照代理和站点分别复用连接, 可以在多个 worker 间安全共享。
+// 配置了 Guard 时返回包装后的 Transport
+func (c TransportConfig) NewTransport(p proxy.ProxyFunc) http.RoundTripper {
+	c = c.withDefaults()
+	dialer := &net.Dialer{
+		Timeout:   c.DialTimeout,
+		KeepAlive: c.KeepAlive,
+	}
+	proxyFunc := http.ProxyFromEnvironment
+	if p != nil {
+		proxyFunc = p
+	}
+	dial := dialer.DialContext
+	if c.Guard != nil {
+		dial = c.Guard.dialContext(dialer)
+		proxyFunc = c.Guard.proxy(proxyFunc)
+	}
+	t := &http.Transport{
+		Proxy:                 recordProxy(proxyFunc),
+		DialContext:           dial,
+		MaxIdleConns:          c.MaxIdleConns,
+		MaxIdleConnsPerHost:   c.MaxIdleConnsPerHost,
+		MaxConnsPerHost:       c.MaxConnsPerHost,
+		IdleConnTimeout:       c.IdleConnTimeout,
+		TLSHandshakeTimeout:   c.TLSHandshakeTimeout,
+		ResponseHeaderTimeout: c.ResponseHeaderTimeout,
+		ExpectContinueTimeout: time.Second,
+		DisableKeepAlives:     c.DisableKeepAlives,
+		ForceAttemptHTTP2:     !c.DisableHTTP2,
+	}
+	if c.TLSConfig != nil {
+		t.TLSClientConfig = c.TLSConfig.Clone()
+	}
+	if c.DisableHTTP2 {
+		// 非空的 TLSNextProto 会关闭 HTTP/2
+		t.TLSNextProto = make(map[string]func(string, *tls.Conn) http.RoundTripper)
+	}
+	if c.Guard != nil {
+		return guardTransport{t}
+	}
+	return t
+}
+
+type proxyUsedKey struct{}
+
+// recordProxy 将请求实际使用的代理记录到请求的 context 中, 参考 withProxyUsed
+func recordProxy(p proxy.ProxyFunc) proxy.ProxyFunc {
+	return func(r *http.Request) (*url.URL, error) {
+		u, err := p(r)
+		if used, ok := r.Context().Value(proxyUsedKey{}).(*string); ok && u != nil {
+			*used = u.Redacted()
+		}
+		return u, err
+	}
+}
+
+// withProxyUsed 返回的字符串在请求完成后为实际使用的代理, 没有使用代理时为空
+func withProxyUsed(req *http.Request) (*http.Request, *string) {
+	used := new(string)
+	return req.WithContext(context.WithValue(req.Context(), proxyUsedKey{}, used)), used
+}
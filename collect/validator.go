@@ -0,0 +1,165 @@
+package collect
+
+import (
+	"bytes"
+	"errors"
+	"fmt"
+	"github.com/PuerkitoBio/goquery"
+	"regexp"
+	"sync"
+)
+
+// ErrBlocked 返回的是网站的封禁页面, 需要更换代理或暂停访问后再重试
+var ErrBlocked = errors.New("block page detected")
+
+// Validator 校验抓取到的响应, 未通过时返回错误
+type Validator interface {
+	Validate(resp *Response) error
+}
+
+type ValidatorFunc func(resp *Response) error
+
+func (f ValidatorFunc) Validate(resp *Response) error {
+	return f(resp)
+}
+
+// ValidateRule 可以在任务配置中声明的响应校验规则, 零值只校验状态码是否为 200
+type ValidateRule struct {
+	StatusCodes     []int    `json:"status_codes"`     // 允许的状态码, 为空时只允许 200
+	ContentTypes    []string `json:"content_types"`    // 允许的媒体类型, 如 text/html
+	MinSize         int      `json:"min_size"`         // 响应体的最小字节数
+	MaxSize         int      `json:"max_size"`         // 响应体的最大字节数
+	Require         []string `json:"require"`          // 响应体必须匹配的正则表达式
+	Forbid          []string `json:"forbid"`           // 响应体不能匹配的正则表达式
+	RequireSelector []string `json:"require_selector"` // 页面中必须存在的 CSS 选择器
+	ForbidSelector  []string `json:"forbid_selector"`  // 页面中不能存在的 CSS 选择器
+	BlockPatterns   []string `json:"block_patterns"`   // 匹配时认为是封禁页面的正则表达式
+	BlockSelectors  []string `json:"block_selectors"`  // 存在时认为是封禁页面的 CSS 选择器
+	// 匹配时认为会话已经过期的正则表达式和 CSS 选择器, 如登录按钮, 参考 Sessions
+	LoggedOutPatterns  []string `json:"logged_out_patterns"`
+	LoggedOutSelectors []string `json:"logged_out_selectors"`
+}
+
+func (v *ValidateRule) Validate(resp *Response) error {
+	statusCodes := v.StatusCodes
+	if len(statusCodes) == 0 {
+		statusCodes = []int{200}
+	}
+	if !containsInt(statusCodes, resp.StatusCode) {
+		return NewStatusError(resp)
+	}
+
+	// 封禁页面可能同时满足其它规则, 需要先判断
+	for _, p := range v.BlockPatterns {
+		if match(p, resp.Body) {
+			return fmt.Errorf("%w: match %q", ErrBlocked, p)
+		}
+	}
+	doc := lazyDocument(resp)
+	for _, sel := range v.BlockSelectors {
+		if d := doc(); d != nil && d.Find(sel).Length() > 0 {
+			return fmt.Errorf("%w: found %q", ErrBlocked, sel)
+		}
+	}
+	for _, p := range v.LoggedOutPatterns {
+		if match(p, resp.Body) {
+			return fmt.Errorf("%w: match %q", ErrSessionExpired, p)
+		}
+	}
+	for _, sel := range v.LoggedOutSelectors {
+		if d := doc(); d != nil && d.Find(sel).Length() > 0 {
+			return fmt.Errorf("%w: found %q", ErrSessionExpired, sel)
+		}
+	}
+
+	if len(v.ContentTypes) > 0 && !containsString(v.ContentTypes, resp.ContentType) {
+		return fmt.Errorf("%w: content type %q", ErrInvalidContent, resp.ContentType)
+	}
+	if v.MinSize > 0 && len(resp.Body) < v.MinSize {
+		return fmt.Errorf("%w: body length %d < %d", ErrInvalidContent, len(resp.Body), v.MinSize)
+	}
+	if v.MaxSize > 0 && len(resp.Body) > v.MaxSize {
+		return fmt.Errorf("%w: body length %d > %d", ErrInvalidContent, len(resp.Body), v.MaxSize)
+	}
+	for _, p := range v.Require {
+		if !match(p, resp.Body) {
+			return fmt.Errorf("%w: not match %q", ErrInvalidContent, p)
+		}
+	}
+	for _, p := range v.Forbid {
+		if match(p, resp.Body) {
+			return fmt.Errorf("%w: match %q", ErrInvalidContent, p)
+		}
+	}
+	for _, sel := range v.RequireSelector {
+		if d := doc(); d == nil || d.Find(sel).Length() == 0 {
+			return fmt.Errorf("%w: not found %q", ErrInvalidContent, sel)
+		}
+	}
+	for _, sel := range v.ForbidSelector {
+		if d := doc(); d != nil && d.Find(sel).Length() > 0 {
+			return fmt.Errorf("%w: found %q", ErrInvalidContent, sel)
+		}
+	}
+	return nil
+}
+
+// Validate 依次使用任务声明的校验规则和自定义的校验器校验响应,
+// 校验结果为会话过期时标记响应使用的会话过期, 下次使用时重新登录
+func (t *Task) Validate(resp *Response) error {
+	err := t.validate(resp)
+	if errors.Is(err, ErrSessionExpired) && resp.Request != nil && resp.Request.Session != nil {
+		resp.Request.Session.Expire()
+	}
+	return err
+}
+
+func (t *Task) validate(resp *Response) error {
+	if err := t.ValidateRule.Validate(resp); err != nil {
+		return err
+	}
+	for _, v := range t.Validators {
+		if err := v.Validate(resp); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// 编译后的正则表达式, 避免每次校验都重新编译
+var regexpCache sync.Map
+
+// match 判断 body 是否匹配正则表达式, 表达式不合法时视为不匹配
+func match(pattern string, body []byte) bool {
+	if re, ok := regexpCache.Load(pattern); ok {
+		return re.(*regexp.Regexp).Match(body)
+	}
+	re, err := regexp.Compile(pattern)
+	if err != nil {
+		return false
+	}
+	regexpCache.Store(pattern, re)
+	return re.Match(body)
+}
+
+// lazyDocument 只在需要时解析一次 HTML
+func lazyDocument(resp *Response) func() *goquery.Document {
+	var doc *goquery.Document
+	var parsed bool
+	return func() *goquery.Document {
+		if !parsed {
+			parsed = true
+			doc, _ = goquery.NewDocumentFromReader(bytes.NewReader(resp.Body))
+		}
+		return doc
+	}
+}
+
+func containsString(list []string, v string) bool {
+	for _, s := range list {
+		if s == v {
+			return true
+		}
+	}
+	return false
+}
@@ -0,0 +1,43 @@
+package collect_test
+
+import (
+	"errors"
+	"github.com/funbinary/crawler/collect"
+	"github.com/stretchr/testify/require"
+	"testing"
+)
+
+func TestValidateRule(t *testing.T) {
+	page := func(status int, body string) *collect.Response {
+		return &collect.Response{StatusCode: status, ContentType: "text/html", Body: []byte(body)}
+	}
+	rule := &collect.ValidateRule{
+		StatusCodes:     []int{200, 404},
+		ContentTypes:    []string{"text/html"},
+		MinSize:         10,
+		RequireSelector: []string{"div.topic-content"},
+		BlockPatterns:   []string{"异常请求"},
+	}
+
+	require.NoError(t, rule.Validate(page(200, `<div class="topic-content">阳台</div>`)))
+	require.NoError(t, rule.Validate(page(404, `<div class="topic-content">阳台</div>`)))
+
+	err := rule.Validate(page(503, ""))
+	var statusErr *collect.StatusError
+	require.True(t, errors.As(err, &statusErr))
+	require.Equal(t, collect.ErrorStatus, collect.ClassifyError(err))
+
+	err = rule.Validate(page(200, `<div class="topic-content">有异常请求从你的 IP 发出</div>`))
+	require.Equal(t, collect.ErrorBlocked, collect.ClassifyError(err))
+
+	err = rule.Validate(page(200, `<div class="aside">............</div>`))
+	require.Equal(t, collect.ErrorContent, collect.ClassifyError(err))
+
+	err = rule.Validate(page(200, "short"))
+	require.ErrorIs(t, err, collect.ErrInvalidContent)
+
+	// 零值只校验状态码
+	var empty collect.ValidateRule
+	require.NoError(t, empty.Validate(page(200, "")))
+	require.Error(t, empty.Validate(page(302, "")))
+}
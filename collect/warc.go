@@ -0,0 +1,466 @@
+package collect
+
+import (
+	"bufio"
+	"bytes"
+	"compress/gzip"
+	"crypto/rand"
+	"crypto/sha1"
+	"encoding/base32"
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+	"net/textproto"
+	"net/url"
+	"os"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+)
+
+// WARCConfig WARC 文件的配置
+type WARCConfig struct {
+	Dir     string // 保存 WARC 文件的目录
+	Prefix  string // 文件名前缀, 为空时使用 crawl
+	MaxSize int64  // 单个文件压缩后的最大字节数, 超过后写入新文件, 为 0 时使用 1GB
+}
+
+// WARC 记录中的自定义字段, 保存请求使用的代理
+const WARCProxyHeader = "WARC-Proxy"
+
+// WARCWriter 将请求和响应以 WARC/1.1 格式写入 gzip 压缩的文件, 每条记录是一个独立的 gzip 成员
+type WARCWriter struct {
+	cfg WARCConfig
+
+	mu   sync.Mutex
+	file *os.File
+	size int64
+	seq  int
+}
+
+func NewWARCWriter(cfg WARCConfig) (*WARCWriter, error) {
+	if cfg.Prefix == "" {
+		cfg.Prefix = "crawl"
+	}
+	if cfg.MaxSize <= 0 {
+		cfg.MaxSize = 1 << 30
+	}
+	if err := os.MkdirAll(cfg.Dir, 0755); err != nil {
+		return nil, err
+	}
+	return &WARCWriter{cfg: cfg}, nil
+}
+
+// WARCRecord WARC 文件中的一条记录
+type WARCRecord struct {
+	Header  textproto.MIMEHeader
+	Content []byte
+}
+
+func (r *WARCRecord) Type() string {
+	return r.Header.Get("WARC-Type")
+}
+
+func (r *WARCRecord) ID() string {
+	return r.Header.Get("WARC-Record-ID")
+}
+
+func (r *WARCRecord) TargetURI() string {
+	return r.Header.Get("WARC-Target-URI")
+}
+
+func (r *WARCRecord) Date() time.Time {
+	t, _ := time.Parse(time.RFC3339, r.Header.Get("WARC-Date"))
+	return t
+}
+
+// Write 写入一对请求和响应记录, 文件超过大小限制时切换到新文件
+func (w *WARCWriter) Write(resp *Response) error {
+	response := newWARCRecord("response", resp.Url, resp.FetchTime, "application/http;msgtype=response", httpResponseBlock(resp))
+	response.Header.Set("WARC-Payload-Digest", digest(resp.RawBody))
+	if resp.Proxy != "" {
+		response.Header.Set(WARCProxyHeader, resp.Proxy)
+	}
+	request := newWARCRecord("request", resp.Url, resp.FetchTime, "application/http;msgtype=request", httpRequestBlock(resp))
+	request.Header.Set("WARC-Concurrent-To", response.ID())
+
+	w.mu.Lock()
+	defer w.mu.Unlock()
+	return w.write(response, request)
+}
+
+func (w *WARCWriter) write(records ...*WARCRecord) error {
+	if w.file == nil || w.size >= w.cfg.MaxSize {
+		if err := w.rotate(); err != nil {
+			return err
+		}
+	}
+	for _, r := range records {
+		n, err := writeGzipRecord(w.file, r)
+		w.size += n
+		if err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+func (w *WARCWriter) rotate() error {
+	if w.file != nil {
+		if err := w.file.Close(); err != nil {
+			return err
+		}
+	}
+	w.seq++
+	name := fmt.Sprintf("%s-%s-%05d.warc.gz", w.cfg.Prefix, time.Now().UTC().Format("20060102150405"), w.seq)
+	file, err := os.OpenFile(filepath.Join(w.cfg.Dir, name), os.O_CREATE|os.O_WRONLY|os.O_EXCL, 0644)
+	if err != nil {
+		return err
+	}
+	w.file, w.size = file, 0
+	info := newWARCRecord("warcinfo", "", time.Now(), "application/warc-fields",
+		[]byte("software: github.com/funbinary/crawler\r\nformat: WARC File Format 1.1\r\n"))
+	info.Header.Set("WARC-Filename", name)
+	n, err := writeGzipRecord(w.file, info)
+	w.size += n
+	return err
+}
+
+func (w *WARCWriter) Close() error {
+	w.mu.Lock()
+	defer w.mu.Unlock()
+	if w.file == nil {
+		return nil
+	}
+	err := w.file.Close()
+	w.file = nil
+	return err
+}
+
+// WARC 将每次成功抓取的请求和响应写入 WARC 文件, 来自缓存的响应不会写入
+func WARC(w *WARCWriter) Middleware {
+	return func(next Fetcher) Fetcher {
+		return FetcherFunc(func(req *Request) (*Response, error) {
+			resp, err := next.Get(req)
+			if err != nil || resp.Cached {
+				return resp, err
+			}
+			if err := w.Write(resp); err != nil {
+				return nil, fmt.Errorf("write warc error: %w", err)
+			}
+			return resp, nil
+		})
+	}
+}
+
+func newWARCRecord(typ string, uri string, date time.Time, contentType string, content []byte) *WARCRecord {
+	h := textproto.MIMEHeader{}
+	h.Set("WARC-Type", typ)
+	h.Set("WARC-Record-ID", "<urn:uuid:"+uuid()+">")
+	h.Set("WARC-Date", date.UTC().Format(time.RFC3339))
+	if uri != "" {
+		h.Set("WARC-Target-URI", uri)
+	}
+	h.Set("Content-Type", contentType)
+	h.Set("WARC-Block-Digest", digest(content))
+	return &WARCRecord{Header: h, Content: content}
+}
+
+// writeGzipRecord 将记录压缩为独立的 gzip 成员写入 w, 返回写入的字节数
+func writeGzipRecord(w io.Writer, r *WARCRecord) (int64, error) {
+	var buf bytes.Buffer
+	zw := gzip.NewWriter(&buf)
+	fmt.Fprintf(zw, "WARC/1.1\r\n")
+	// 按固定顺序写入字段, 便于比较
+	keys := []string{"WARC-Type", "WARC-Record-ID", "WARC-Date", "WARC-Target-URI", "WARC-Concurrent-To",
+		"WARC-Filename", "Content-Type", "WARC-Block-Digest", "WARC-Payload-Digest", WARCProxyHeader}
+	for _, k := range keys {
+		if v := r.Header.Get(k); v != "" {
+			fmt.Fprintf(zw, "%s: %s\r\n", k, v)
+		}
+	}
+	fmt.Fprintf(zw, "Content-Length: %d\r\n\r\n", len(r.Content))
+	zw.Write(r.Content)
+	zw.Write([]byte("\r\n\r\n"))
+	if err := zw.Close(); err != nil {
+		return 0, err
+	}
+	n, err := w.Write(buf.Bytes())
+	return int64(n), err
+}
+
+// httpResponseBlock 还原响应的状态行、响应头和原始响应体。
+// 抓取器已经解压了响应体, 因此去掉 Content-Encoding 并重新设置 Content-Length
+func httpResponseBlock(resp *Response) []byte {
+	var buf bytes.Buffer
+	proto := resp.Proto
+	if proto == "" {
+		proto = "HTTP/1.1"
+	}
+	fmt.Fprintf(&buf, "%s %d %s\r\n", proto, resp.StatusCode, http.StatusText(resp.StatusCode))
+	header := resp.Header.Clone()
+	if header == nil {
+		header = http.Header{}
+	}
+	header.Del("Content-Encoding")
+	header.Del("Transfer-Encoding")
+	header.Set("Content-Length", strconv.Itoa(len(resp.RawBody)))
+	header.Write(&buf)
+	buf.WriteString("\r\n")
+	buf.Write(resp.RawBody)
+	return buf.Bytes()
+}
+
+// httpRequestBlock 还原最后一次发送的请求, 经过重定向的请求不包含请求体
+func httpRequestBlock(resp *Response) []byte {
+	var buf bytes.Buffer
+	method, uri, host := "GET", "/", ""
+	var body []byte
+	if u, err := url.Parse(resp.Url); err == nil {
+		uri, host = u.RequestURI(), u.Host
+	}
+	if req := resp.Request; req != nil && req.FullUrl() == resp.Url {
+		method = req.method()
+		body, _, _ = req.Payload()
+	}
+	fmt.Fprintf(&buf, "%s %s HTTP/1.1\r\nHost: %s\r\n", method, uri, host)
+	header := resp.RequestHeader
+	if header == nil && resp.Request != nil {
+		header = resp.Request.Header
+	}
+	header = header.Clone()
+	if header == nil {
+		header = http.Header{}
+	}
+	if len(body) > 0 {
+		header.Set("Content-Length", strconv.Itoa(len(body)))
+	}
+	header.Write(&buf)
+	buf.WriteString("\r\n")
+	buf.Write(body)
+	return buf.Bytes()
+}
+
+func digest(b []byte) string {
+	sum := sha1.Sum(b)
+	return "sha1:" + base32.StdEncoding.EncodeToString(sum[:])
+}
+
+func uuid() string {
+	var b [16]byte
+	rand.Read(b[:])
+	b[6] = b[6]&0x0f | 0x40
+	b[8] = b[8]&0x3f | 0x80
+	return fmt.Sprintf("%x-%x-%x-%x-%x", b[0:4], b[4:6], b[6:8], b[8:10], b[10:])
+}
+
+// WARCReader 按顺序读取 WARC 文件中的记录, 支持 gzip 压缩和未压缩的文件
+type WARCReader struct {
+	r      *bufio.Reader
+	closer io.Closer
+}
+
+func OpenWARC(path string) (*WARCReader, error) {
+	file, err := os.Open(path)
+	if err != nil {
+		return nil, err
+	}
+	r, err := NewWARCReader(file)
+	if err != nil {
+		file.Close()
+		return nil, err
+	}
+	r.closer = file
+	return r, nil
+}
+
+func NewWARCReader(r io.Reader) (*WARCReader, error) {
+	br := bufio.NewReader(r)
+	magic, err := br.Peek(2)
+	if err == nil && magic[0] == 0x1f && magic[1] == 0x8b {
+		// 多个 gzip 成员会被连续读取
+		zr, err := gzip.NewReader(br)
+		if err != nil {
+			return nil, err
+		}
+		br = bufio.NewReader(zr)
+	}
+	return &WARCReader{r: br}, nil
+}
+
+// Next 读取下一条记录, 没有更多记录时返回 io.EOF
+func (r *WARCReader) Next() (*WARCRecord, error) {
+	var version string
+	for {
+		line, err := r.r.ReadString('\n')
+		if err != nil {
+			if err == io.EOF && strings.TrimSpace(line) == "" {
+				return nil, io.EOF
+			}
+			return nil, err
+		}
+		if version = strings.TrimSpace(line); version != "" {
+			break
+		}
+	}
+	if !strings.HasPrefix(version, "WARC/") {
+		return nil, fmt.Errorf("invalid warc record: %q", version)
+	}
+	header, err := textproto.NewReader(r.r).ReadMIMEHeader()
+	if err != nil {
+		return nil, err
+	}
+	length, err := strconv.ParseInt(header.Get("Content-Length"), 10, 64)
+	if err != nil {
+		return nil, fmt.Errorf("invalid warc content length: %w", err)
+	}
+	content := make([]byte, length)
+	if _, err := io.ReadFull(r.r, content); err != nil {
+		return nil, err
+	}
+	return &WARCRecord{Header: header, Content: content}, nil
+}
+
+func (r *WARCReader) Close() error {
+	if r.closer == nil {
+		return nil
+	}
+	return r.closer.Close()
+}
+
+// Response 将响应记录还原为抓取器返回的响应, 响应体会转换为 utf-8
+func (r *WARCRecord) Response() (*Response, error) {
+	if r.Type() != "response" {
+		return nil, fmt.Errorf("warc record %s is not a response", r.ID())
+	}
+	resp, err := http.ReadResponse(bufio.NewReader(bytes.NewReader(r.Content)), nil)
+	if err != nil {
+		return nil, err
+	}
+	defer resp.Body.Close()
+	raw, err := io.ReadAll(resp.Body)
+	if err != nil && !errors.Is(err, io.ErrUnexpectedEOF) {
+		return nil, err
+	}
+	body, err := decodeBody(raw, resp.Header.Get("Content-Type"))
+	if err != nil {
+		return nil, err
+	}
+	response := &Response{
+		StatusCode: resp.StatusCode,
+		Header:     resp.Header,
+		Url:        r.TargetURI(),
+		Body:       body,
+		FetchTime:  r.Date(),
+		Proto:      resp.Proto,
+		RawBody:    raw,
+		Proxy:      r.Header.Get(WARCProxyHeader),
+	}
+	response.ContentType = mediaType(resp.Header.Get("Content-Type"))
+	return response, nil
+}
+
+// request 将请求记录还原为请求, 用于计算请求的唯一识别码
+func (r *WARCRecord) request() (*Request, error) {
+	req, err := http.ReadRequest(bufio.NewReader(bytes.NewReader(r.Content)))
+	if err != nil {
+		return nil, err
+	}
+	defer req.Body.Close()
+	body, err := io.ReadAll(req.Body)
+	if err != nil {
+		return nil, err
+	}
+	return &Request{Url: r.TargetURI(), Method: req.Method, Header: req.Header, Body: body}, nil
+}
+
+// ReadWARC 依次读取 WARC 文件中的响应记录, fn 返回错误时停止读取
+func ReadWARC(path string, fn func(resp *Response) error) error {
+	r, err := OpenWARC(path)
+	if err != nil {
+		return err
+	}
+	defer r.Close()
+	for {
+		record, err := r.Next()
+		if err == io.EOF {
+			return nil
+		}
+		if err != nil {
+			return err
+		}
+		if record.Type() != "response" {
+			continue
+		}
+		resp, err := record.Response()
+		if err != nil {
+			return err
+		}
+		if err := fn(resp); err != nil {
+			return err
+		}
+	}
+}
+
+// NewWARCReplayFetcher 使用 WARC 文件中的响应回放请求, 可以将存档的抓取结果重新交给解析规则处理。
+// 记录中只保存了重定向之后的请求, 因此发生过重定向的响应需要使用最终的 URL 请求
+func NewWARCReplayFetcher(paths []string, fallback Fetcher) (*ReplayFetcher, error) {
+	f := newReplayFetcher(fallback)
+	for _, path := range paths {
+		if err := f.loadWARC(path); err != nil {
+			return nil, fmt.Errorf("load warc %s error: %w", path, err)
+		}
+	}
+	return f, nil
+}
+
+func (f *ReplayFetcher) loadWARC(path string) error {
+	r, err := OpenWARC(path)
+	if err != nil {
+		return err
+	}
+	defer r.Close()
+	// 响应记录先于对应的请求记录写入, 没有请求记录的响应视为 GET 请求
+	responses := make(map[string]*Response)
+	var order []string
+	for {
+		record, err := r.Next()
+		if err == io.EOF {
+			break
+		}
+		if err != nil {
+			return err
+		}
+		switch record.Type() {
+		case "response":
+			resp, err := record.Response()
+			if err != nil {
+				return err
+			}
+			responses[record.ID()] = resp
+			order = append(order, record.ID())
+		case "request":
+			resp, ok := responses[record.Header.Get("WARC-Concurrent-To")]
+			if !ok {
+				continue
+			}
+			req, err := record.request()
+			if err != nil {
+				return err
+			}
+			resp.Request = req
+		}
+	}
+	for _, id := range order {
+		resp := responses[id]
+		req := resp.Request
+		if req == nil {
+			req = &Request{Url: resp.Url, Method: "GET"}
+		}
+		f.add(req.Unique(), newCacheEntry(resp))
+	}
+	return nil
+}
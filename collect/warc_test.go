@@ -0,0 +1,95 @@
+package collect_test
+
+import (
+	"github.com/funbinary/crawler/collect"
+	"github.com/stretchr/testify/require"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"path/filepath"
+	"testing"
+	"time"
+)
+
+func TestWARC(t *testing.T) {
+	// 测试服务器同时作为代理
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		r.ParseForm()
+		w.Header().Set("Content-Type", "text/html; charset=utf-8")
+		w.Write([]byte("<html>" + r.Method + " " + r.URL.Path + " " + r.Form.Get("q") + "</html>"))
+	}))
+	defer server.Close()
+	proxyUrl, _ := url.Parse(server.URL)
+
+	dir := t.TempDir()
+	w, err := collect.NewWARCWriter(collect.WARCConfig{Dir: dir, MaxSize: 1})
+	require.NoError(t, err)
+	f := collect.Chain(&collect.BrowserFetch{
+		Timeout: time.Second,
+		Proxy: func(r *http.Request) (*url.URL, error) {
+			return proxyUrl, nil
+		},
+	}, collect.WARC(w))
+	reqs := []*collect.Request{
+		{Url: "http://example.com/a", Header: http.Header{"X-Test": {"1"}}},
+		{Url: "http://example.com/search", Method: "POST", Form: url.Values{"q": {"go"}}},
+	}
+	for _, req := range reqs {
+		resp, err := f.Get(req)
+		require.NoError(t, err)
+		require.Equal(t, server.URL, resp.Proxy)
+	}
+	require.NoError(t, w.Close())
+
+	// 超过大小限制后每对记录写入新文件
+	paths, err := filepath.Glob(filepath.Join(dir, "*.warc.gz"))
+	require.NoError(t, err)
+	require.Len(t, paths, 2)
+
+	r, err := collect.OpenWARC(paths[1])
+	require.NoError(t, err)
+	var types []string
+	for {
+		record, err := r.Next()
+		if err == io.EOF {
+			break
+		}
+		require.NoError(t, err)
+		types = append(types, record.Type())
+		if record.Type() == "request" {
+			require.Contains(t, string(record.Content), "POST /search HTTP/1.1\r\nHost: example.com\r\n")
+			require.Contains(t, string(record.Content), "q=go")
+		}
+		if record.Type() == "response" {
+			require.Equal(t, server.URL, record.Header.Get(collect.WARCProxyHeader))
+			require.Equal(t, "http://example.com/search", record.TargetURI())
+		}
+	}
+	require.NoError(t, r.Close())
+	require.Equal(t, []string{"warcinfo", "response", "request"}, types)
+
+	var urls []string
+	for _, path := range paths {
+		require.NoError(t, collect.ReadWARC(path, func(resp *collect.Response) error {
+			urls = append(urls, resp.Url)
+			return nil
+		}))
+	}
+	require.ElementsMatch(t, []string{"http://example.com/a", "http://example.com/search"}, urls)
+
+	// 回放时按请求方法和请求体区分请求
+	replay, err := collect.NewWARCReplayFetcher(paths, nil)
+	require.NoError(t, err)
+	server.Close()
+	for _, req := range reqs {
+		resp, err := replay.Get(req)
+		require.NoError(t, err)
+		require.Equal(t, "text/html", resp.ContentType)
+	}
+	resp, err := replay.Get(reqs[1])
+	require.NoError(t, err)
+	require.Equal(t, "<html>POST /search go</html>", string(resp.Body))
+	_, err = replay.Get(&collect.Request{Url: "http://example.com/search"})
+	require.ErrorIs(t, err, collect.ErrNotRecorded)
+}
@@ -0,0 +1,87 @@
+package dedup
+
+import (
+	"hash/fnv"
+	"math"
+	"sync"
+)
+
+// BloomDeduper 使用布隆过滤器去重, 内存占用固定, 但有一定概率把新请求误判为已访问, 且不支持删除
+type BloomDeduper struct {
+	mu   sync.Mutex
+	bits []uint64
+	m    uint64 // 位数
+	k    uint64 // 哈希函数个数
+}
+
+// NewBloomDeduper 根据预计的请求数 n 和允许的误判率 p 创建布隆过滤器
+func NewBloomDeduper(n uint64, p float64) *BloomDeduper {
+	if n == 0 {
+		n = 1
+	}
+	if p <= 0 || p >= 1 {
+		p = 0.001
+	}
+	m := uint64(math.Ceil(-float64(n) * math.Log(p) / (math.Ln2 * math.Ln2)))
+	k := uint64(math.Round(float64(m) / float64(n) * math.Ln2))
+	if k < 1 {
+		k = 1
+	}
+	return &BloomDeduper{
+		bits: make([]uint64, (m+63)/64),
+		m:    m,
+		k:    k,
+	}
+}
+
+// locations 使用双重哈希计算 key 对应的 k 个位置
+func (d *BloomDeduper) locations(key string) []uint64 {
+	h := fnv.New64a()
+	h.Write([]byte(key))
+	h1 := h.Sum64()
+	h2 := h1>>33 | h1<<31
+	h2 |= 1
+	locs := make([]uint64, d.k)
+	for i := uint64(0); i < d.k; i++ {
+		locs[i] = (h1 + i*h2) % d.m
+	}
+	return locs
+}
+
+func (d *BloomDeduper) contains(locs []uint64) bool {
+	for _, l := range locs {
+		if d.bits[l/64]&(1<<(l%64)) == 0 {
+			return false
+		}
+	}
+	return true
+}
+
+func (d *BloomDeduper) Contains(key string) (bool, error) {
+	locs := d.locations(key)
+	d.mu.Lock()
+	defer d.mu.Unlock()
+	return d.contains(locs), nil
+}
+
+func (d *BloomDeduper) Add(key string) (bool, error) {
+	locs := d.locations(key)
+	d.mu.Lock()
+	defer d.mu.Unlock()
+	if d.contains(locs) {
+		return false, nil
+	}
+	for _, l := range locs {
+		d.bits[l/64] |= 1 << (l % 64)
+	}
+	return true, nil
+}
+
+// Remove 布隆过滤器不支持删除
+func (d *BloomDeduper) Remove(key string) error {
+	return nil
+}
+
+func (d *BloomDeduper) Close() error {
+	return nil
+}
@@ -0,0 +1,51 @@
+package dedup
+
+import "sync"
+
+// Deduper 记录已经访问过的请求, key 为请求的唯一识别码
+type Deduper interface {
+	Contains(key string) (bool, error) // key 是否已经存在
+	Add(key string) (bool, error)      // 添加 key, key 已经存在时返回 false
+	Remove(key string) error           // 删除 key, 不支持删除的实现会忽略
+	Close() error
+}
+
+// MemoryDeduper 使用内存中的 map 去重, 精确但会随请求数增长, 进程退出后丢失
+type MemoryDeduper struct {
+	mu      sync.Mutex
+	visited map[string]struct{}
+}
+
+func NewMemoryDeduper() *MemoryDeduper {
+	return &MemoryDeduper{
+		visited: make(map[string]struct{}, 100),
+	}
+}
+
+func (d *MemoryDeduper) Contains(key string) (bool, error) {
+	d.mu.Lock()
+	defer d.mu.Unlock()
+	_, ok := d.visited[key]
+	return ok, nil
+}
+
+func (d *MemoryDeduper) Add(key string) (bool, error) {
+	d.mu.Lock()
+	defer d.mu.Unlock()
+	if _, ok := d.visited[key]; ok {
+		return false, nil
+	}
+	d.visited[key] = struct{}{}
+	return true, nil
+}
+
+func (d *MemoryDeduper) Remove(key string) error {
+	d.mu.Lock()
+	defer d.mu.Unlock()
+	delete(d.visited, key)
+	return nil
+}
+
+func (d *MemoryDeduper) Close() error {
+	return nil
+}
@@ -0,0 +1,100 @@
+package dedup_test
+
+import (
+	"bytes"
+	"fmt"
+	"github.com/funbinary/crawler/dedup"
+	"github.com/stretchr/testify/require"
+	"path/filepath"
+	"testing"
+)
+
+func TestDeduper(t *testing.T) {
+	disk, err := dedup.NewDiskDeduper(filepath.Join(t.TempDir(), "visited.db"))
+	require.NoError(t, err)
+	dedupers := map[string]dedup.Deduper{
+		"memory": dedup.NewMemoryDeduper(),
+		"bloom":  dedup.NewBloomDeduper(1000, 0.001),
+		"disk":   disk,
+	}
+	for name, d := range dedupers {
+		t.Run(name, func(t *testing.T) {
+			added, err := d.Add("a")
+			require.NoError(t, err)
+			require.True(t, added)
+
+			added, err = d.Add("a")
+			require.NoError(t, err)
+			require.False(t, added)
+
+			ok, err := d.Contains("a")
+			require.NoError(t, err)
+			require.True(t, ok)
+
+			ok, err = d.Contains("b")
+			require.NoError(t, err)
+			require.False(t, ok)
+			require.NoError(t, d.Close())
+		})
+	}
+}
+
+func TestBloomFalsePositive(t *testing.T) {
+	d := dedup.NewBloomDeduper(10000, 0.01)
+	for i := 0; i < 10000; i++ {
+		_, err := d.Add(fmt.Sprintf("visited-%d", i))
+		require.NoError(t, err)
+	}
+	var falsePositive int
+	for i := 0; i < 10000; i++ {
+		ok, _ := d.Contains(fmt.Sprintf("new-%d", i))
+		if ok {
+			falsePositive++
+		}
+	}
+	require.Less(t, falsePositive, 300)
+}
+
+func TestDiskDeduperReopen(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "visited.db")
+	d, err := dedup.NewDiskDeduper(path)
+	require.NoError(t, err)
+	_, err = d.Add("a")
+	require.NoError(t, err)
+	require.NoError(t, d.Close())
+
+	d, err = dedup.NewDiskDeduper(path)
+	require.NoError(t, err)
+	defer d.Close()
+	ok, err := d.Contains("a")
+	require.NoError(t, err)
+	require.True(t, ok)
+}
+
+func TestBloomPersist(t *testing.T) {
+	d := dedup.NewBloomDeduper(1000, 0.001)
+	_, err := d.Add("a")
+	require.NoError(t, err)
+	var buf bytes.Buffer
+	require.NoError(t, d.Save(&buf))
+
+	loaded := dedup.NewBloomDeduper(10, 0.1)
+	require.NoError(t, loaded.Load(&buf))
+	ok, err := loaded.Contains("a")
+	require.NoError(t, err)
+	require.True(t, ok)
+	ok, err = loaded.Contains("b")
+	require.NoError(t, err)
+	require.False(t, ok)
+
+	// 损坏的文件返回错误, 不能导致 panic
+	for name, data := range map[string][]byte{
+		"empty":  nil,
+		"short":  make([]byte, 12),
+		"zero m": make([]byte, 16),
+	} {
+		t.Run(name, func(t *testing.T) {
+			require.Error(t, dedup.NewBloomDeduper(10, 0.1).Load(bytes.NewReader(data)))
+		})
+	}
+}
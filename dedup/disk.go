@@ -0,0 +1,63 @@
+package dedup
+
+import (
+	"github.com/pkg/errors"
+	bolt "go.etcd.io/bbolt"
+	"time"
+)
+
+var visitedBucket = []byte("visited")
+
+// DiskDeduper 使用磁盘上的 bbolt 数据库去重, 内存占用小, 进程重启后仍然有效
+type DiskDeduper struct {
+	db *bolt.DB
+}
+
+// NewDiskDeduper 打开或创建 path 处的数据库
+func NewDiskDeduper(path string) (*DiskDeduper, error) {
+	db, err := bolt.Open(path, 0644, &bolt.Options{Timeout: time.Second})
+	if err != nil {
+		return nil, errors.Wrap(err, "open dedup db error")
+	}
+	err = db.Update(func(tx *bolt.Tx) error {
+		_, err := tx.CreateBucketIfNotExists(visitedBucket)
+		return err
+	})
+	if err != nil {
+		db.Close()
+		return nil, errors.Wrap(err, "create dedup bucket error")
+	}
+	return &DiskDeduper{db: db}, nil
+}
+
+func (d *DiskDeduper) Contains(key string) (bool, error) {
+	var ok bool
+	err := d.db.View(func(tx *bolt.Tx) error {
+		ok = tx.Bucket(visitedBucket).Get([]byte(key)) != nil
+		return nil
+	})
+	return ok, err
+}
+
+func (d *DiskDeduper) Add(key string) (bool, error) {
+	var added bool
+	err := d.db.Update(func(tx *bolt.Tx) error {
+		b := tx.Bucket(visitedBucket)
+		if b.Get([]byte(key)) != nil {
+			return nil
+		}
+		added = true
+		return b.Put([]byte(key), []byte{1})
+	})
+	return added, err
+}
+
+func (d *DiskDeduper) Remove(key string) error {
+	return d.db.Update(func(tx *bolt.Tx) error {
+		return tx.Bucket(visitedBucket).Delete([]byte(key))
+	})
+}
+
+func (d *DiskDeduper) Close() error {
+	return d.db.Close()
+}
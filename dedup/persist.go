@@ -0,0 +1,74 @@
+package dedup
+
+import (
+	"bufio"
+	"encoding/binary"
+	"fmt"
+	"io"
+)
+
+// Persister 可以保存到文件并从文件恢复的去重器, 用于爬取的断点续爬。
+// DiskDeduper 的数据本身保存在磁盘上, 不需要实现该接口。
+type Persister interface {
+	Save(w io.Writer) error
+	Load(r io.Reader) error
+}
+
+// Save 每行保存一个 key
+func (d *MemoryDeduper) Save(w io.Writer) error {
+	d.mu.Lock()
+	defer d.mu.Unlock()
+	bw := bufio.NewWriter(w)
+	for key := range d.visited {
+		if _, err := bw.WriteString(key + "\n"); err != nil {
+			return err
+		}
+	}
+	return bw.Flush()
+}
+
+func (d *MemoryDeduper) Load(r io.Reader) error {
+	d.mu.Lock()
+	defer d.mu.Unlock()
+	scanner := bufio.NewScanner(r)
+	for scanner.Scan() {
+		if key := scanner.Text(); key != "" {
+			d.visited[key] = struct{}{}
+		}
+	}
+	return scanner.Err()
+}
+
+// Save 依次保存位数、哈希函数个数和所有的位
+func (d *BloomDeduper) Save(w io.Writer) error {
+	d.mu.Lock()
+	defer d.mu.Unlock()
+	bw := bufio.NewWriter(w)
+	if err := binary.Write(bw, binary.LittleEndian, []uint64{d.m, d.k}); err != nil {
+		return err
+	}
+	if err := binary.Write(bw, binary.LittleEndian, d.bits); err != nil {
+		return err
+	}
+	return bw.Flush()
+}
+
+// Load 恢复 Save 保存的布隆过滤器, 会覆盖创建时的参数
+func (d *BloomDeduper) Load(r io.Reader) error {
+	br := bufio.NewReader(r)
+	header := make([]uint64, 2)
+	if err := binary.Read(br, binary.LittleEndian, header); err != nil {
+		return fmt.Errorf("read bloom filter header error: %w", err)
+	}
+	if header[0] == 0 || header[1] == 0 {
+		return fmt.Errorf("invalid bloom filter header: m=%d k=%d", header[0], header[1])
+	}
+	bits := make([]uint64, (header[0]+63)/64)
+	if err := binary.Read(br, binary.LittleEndian, bits); err != nil {
+		return err
+	}
+	d.mu.Lock()
+	defer d.mu.Unlock()
+	d.m, d.k, d.bits = header[0], header[1], bits
+	return nil
+}
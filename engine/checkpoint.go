@@ -0,0 +1,289 @@
+package engine
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"github.com/funbinary/crawler/collect"
+	"github.com/funbinary/crawler/dedup"
+	"github.com/pkg/errors"
+	"go.uber.org/zap"
+	"io"
+	"net/http"
+	"net/url"
+	"os"
+	"path/filepath"
+	"sync/atomic"
+	"time"
+)
+
+const (
+	checkpointFile  = "checkpoint.json"
+	deadLetterFile  = "deadletters.jsonl"
+	visitedFile     = "visited.bin"
+	taskVisitedFile = "visited_%s.bin"
+)
+
+// requestRecord 请求保存到文件时的格式, 任务通过 Store 中注册的任务名查找
+type requestRecord struct {
+	Task     string                 `json:"task"`
+	Url      string                 `json:"url"`
+	Method   string                 `json:"method"`
+	RuleName string                 `json:"rule_name"`
+	Priority int64                  `json:"priority"`
+	Depth    int64                  `json:"depth"`
+	Attempt  int                    `json:"attempt"`
+	Header   http.Header            `json:"header,omitempty"`
+	Query    url.Values             `json:"query,omitempty"`
+	Form     url.Values             `json:"form,omitempty"`
+	JSON     interface{}            `json:"json,omitempty"`
+	Body     []byte                 `json:"body,omitempty"`
+	Meta     map[string]interface{} `json:"meta,omitempty"`
+}
+
+func newRequestRecord(req *collect.Request) requestRecord {
+	r := requestRecord{
+		Url:      req.Url,
+		Method:   req.Method,
+		RuleName: req.RuleName,
+		Priority: req.Priority,
+		Depth:    req.Depth,
+		Attempt:  req.Attempt,
+		Header:   req.Header,
+		Query:    req.Query,
+		Form:     req.Form,
+		JSON:     req.JSON,
+		Body:     req.Body,
+		Meta:     req.Meta,
+	}
+	if req.Task != nil {
+		r.Task = req.Task.Name
+	}
+	return r
+}
+
+func (r requestRecord) request() (*collect.Request, error) {
+	task, ok := Store.hash[r.Task]
+	if !ok {
+		return nil, errors.Errorf("task %s not found", r.Task)
+	}
+	return &collect.Request{
+		Task:     task,
+		Url:      r.Url,
+		Method:   r.Method,
+		RuleName: r.RuleName,
+		Priority: r.Priority,
+		Depth:    r.Depth,
+		Attempt:  r.Attempt,
+		Header:   r.Header,
+		Query:    r.Query,
+		Form:     r.Form,
+		JSON:     r.JSON,
+		Body:     r.Body,
+		Meta:     r.Meta,
+	}, nil
+}
+
+// checkpoint 某一时刻爬取的进度
+type checkpoint struct {
+	Time     time.Time        `json:"time"`
+	Finished bool             `json:"finished"` // 爬取已经完成
+	Stats    Stats            `json:"stats"`
+	Pages    map[string]int64 `json:"pages,omitempty"` // 任务名 -> 已经抓取的页面数
+	Requests []requestRecord  `json:"requests"`        // 队列中、正在处理和等待重试的请求
+}
+
+// checkpointDir 当前爬取的断点保存目录
+func (e *Crawler) checkpointDir() string {
+	return filepath.Join(e.CheckpointDir, e.CrawlName)
+}
+
+// track 记录尚未处理完成的请求, 同一个请求被延迟重新放入调度器时会记录多次
+func (e *Crawler) track(reqs ...*collect.Request) {
+	e.outstandingLock.Lock()
+	defer e.outstandingLock.Unlock()
+	for _, req := range reqs {
+		e.outstanding[req]++
+	}
+}
+
+func (e *Crawler) untrack(req *collect.Request) {
+	e.outstandingLock.Lock()
+	defer e.outstandingLock.Unlock()
+	if e.outstanding[req] <= 1 {
+		delete(e.outstanding, req)
+		return
+	}
+	e.outstanding[req]--
+}
+
+// runCheckpoint 每隔 CheckpointInterval 保存一次断点, 直到 ctx 取消
+func (e *Crawler) runCheckpoint(ctx context.Context) {
+	ticker := time.NewTicker(e.CheckpointInterval)
+	defer ticker.Stop()
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+			if err := e.Checkpoint(); err != nil {
+				e.Logger.Error("save checkpoint failed", zap.Error(err))
+			}
+		}
+	}
+}
+
+// Checkpoint 将尚未完成的请求、死信队列、去重状态和统计信息保存到断点目录
+func (e *Crawler) Checkpoint() error {
+	if e.CheckpointDir == "" {
+		return errors.New("checkpoint dir is not set")
+	}
+	e.checkpointLock.Lock()
+	defer e.checkpointLock.Unlock()
+
+	dir := e.checkpointDir()
+	if err := os.MkdirAll(dir, 0755); err != nil {
+		return errors.Wrap(err, "create checkpoint dir error")
+	}
+
+	// 先保存去重状态, 保证恢复后不会漏掉之后才完成的请求
+	for name, d := range e.dedupers() {
+		p, ok := d.(dedup.Persister)
+		if !ok {
+			continue
+		}
+		if err := writeFile(filepath.Join(dir, name), p.Save); err != nil {
+			return errors.Wrap(err, "save visited error")
+		}
+	}
+	if err := writeFile(filepath.Join(dir, deadLetterFile), e.deadLetters.Export); err != nil {
+		return errors.Wrap(err, "save dead letters error")
+	}
+
+	cp := checkpoint{
+		Time:     time.Now(),
+		Finished: e.finished.Load(),
+		Stats:    e.Stats(),
+		Pages:    make(map[string]int64),
+	}
+	e.pages.Range(func(k, v interface{}) bool {
+		cp.Pages[k.(string)] = v.(*atomic.Int64).Load()
+		return true
+	})
+	e.outstandingLock.Lock()
+	for req := range e.outstanding {
+		cp.Requests = append(cp.Requests, newRequestRecord(req))
+	}
+	e.outstandingLock.Unlock()
+
+	err := writeFile(filepath.Join(dir, checkpointFile), func(w io.Writer) error {
+		return json.NewEncoder(w).Encode(cp)
+	})
+	if err != nil {
+		return errors.Wrap(err, "save checkpoint error")
+	}
+	e.Logger.Info("checkpoint saved",
+		zap.String("dir", dir),
+		zap.Int("requests", len(cp.Requests)),
+	)
+	return nil
+}
+
+// restore 从断点目录恢复去重状态、死信队列和统计信息, 返回断点和尚未完成的请求。
+// 断点不存在时返回 nil。
+func (e *Crawler) restore() (*checkpoint, []*collect.Request, error) {
+	dir := e.checkpointDir()
+	f, err := os.Open(filepath.Join(dir, checkpointFile))
+	if os.IsNotExist(err) {
+		return nil, nil, nil
+	}
+	if err != nil {
+		return nil, nil, errors.Wrap(err, "open checkpoint error")
+	}
+	defer f.Close()
+	var cp checkpoint
+	if err := json.NewDecoder(f).Decode(&cp); err != nil {
+		return nil, nil, errors.Wrap(err, "decode checkpoint error")
+	}
+
+	for name, d := range e.dedupers() {
+		p, ok := d.(dedup.Persister)
+		if !ok {
+			continue
+		}
+		if err := readFile(filepath.Join(dir, name), p.Load); err != nil {
+			return nil, nil, errors.Wrap(err, "load visited error")
+		}
+	}
+	if err := readFile(filepath.Join(dir, deadLetterFile), e.deadLetters.Import); err != nil {
+		return nil, nil, errors.Wrap(err, "load dead letters error")
+	}
+
+	reqs := make([]*collect.Request, 0, len(cp.Requests))
+	for _, r := range cp.Requests {
+		req, err := r.request()
+		if err != nil {
+			return nil, nil, err
+		}
+		// 保存时可能正在处理, 已经被记录为访问过
+		e.resumed.Store(req, struct{}{})
+		reqs = append(reqs, req)
+	}
+
+	e.stats.requests.Store(cp.Stats.Requests)
+	e.stats.success.Store(cp.Stats.Success)
+	e.stats.failures.Store(cp.Stats.Failures)
+	e.stats.retries.Store(cp.Stats.Retries)
+	e.stats.duplicates.Store(cp.Stats.Duplicates)
+	e.stats.disallowed.Store(cp.Stats.Disallowed)
+	e.stats.rejected.Store(cp.Stats.Rejected)
+	e.stats.items.Store(cp.Stats.Items)
+	for name, n := range cp.Pages {
+		e.taskPages(name).Store(n)
+	}
+	return &cp, reqs, nil
+}
+
+// dedupers 需要保存的去重器, 文件名 -> 去重器
+func (e *Crawler) dedupers() map[string]dedup.Deduper {
+	ds := map[string]dedup.Deduper{visitedFile: e.Deduper}
+	for _, seed := range e.Seeds {
+		if task, ok := Store.hash[seed.Name]; ok && task.Deduper != nil {
+			ds[fmt.Sprintf(taskVisitedFile, task.Name)] = task.Deduper
+		}
+	}
+	return ds
+}
+
+// writeFile 先写入临时文件再重命名, 避免保存过程中退出导致文件损坏
+func writeFile(path string, write func(w io.Writer) error) error {
+	tmp := path + ".tmp"
+	f, err := os.Create(tmp)
+	if err != nil {
+		return err
+	}
+	if err := write(f); err != nil {
+		f.Close()
+		return err
+	}
+	if err := f.Sync(); err != nil {
+		f.Close()
+		return err
+	}
+	if err := f.Close(); err != nil {
+		return err
+	}
+	return os.Rename(tmp, path)
+}
+
+func readFile(path string, read func(r io.Reader) error) error {
+	f, err := os.Open(path)
+	if os.IsNotExist(err) {
+		return nil
+	}
+	if err != nil {
+		return err
+	}
+	defer f.Close()
+	return read(f)
+}
@@ -0,0 +1,156 @@
+package engine
+
+import (
+	"bufio"
+	"encoding/json"
+	"github.com/funbinary/crawler/collect"
+	"github.com/pkg/errors"
+	"io"
+	"sync"
+	"time"
+)
+
+// DeadLetter 重试次数用尽后仍然失败的请求
+type DeadLetter struct {
+	Req   *collect.Request
+	Err   string    // 最后一次失败的原因
+	Class string    // 最后一次失败的原因分类
+	Time  time.Time // 放入死信队列的时间
+}
+
+// deadLetterRecord 死信导出时的格式
+type deadLetterRecord struct {
+	requestRecord
+	Err   string    `json:"error"`
+	Class string    `json:"class"`
+	Time  time.Time `json:"time"`
+}
+
+// DeadLetterQueue 死信队列, 保存最终失败的请求, 可以查看、导出并重新放入调度器
+type DeadLetterQueue struct {
+	mu      sync.Mutex
+	letters map[string]*DeadLetter // 请求id -> 死信
+	order   []string
+}
+
+func NewDeadLetterQueue() *DeadLetterQueue {
+	return &DeadLetterQueue{
+		letters: make(map[string]*DeadLetter),
+	}
+}
+
+// Add 放入一个失败的请求, 相同的请求只保留最后一次
+func (q *DeadLetterQueue) Add(req *collect.Request, err error) {
+	q.mu.Lock()
+	defer q.mu.Unlock()
+	q.add(&DeadLetter{
+		Req:   req,
+		Err:   err.Error(),
+		Class: string(collect.ClassifyError(err)),
+		Time:  time.Now(),
+	})
+}
+
+func (q *DeadLetterQueue) add(l *DeadLetter) {
+	unique := l.Req.Unique()
+	if _, ok := q.letters[unique]; !ok {
+		q.order = append(q.order, unique)
+	}
+	q.letters[unique] = l
+}
+
+func (q *DeadLetterQueue) Len() int {
+	q.mu.Lock()
+	defer q.mu.Unlock()
+	return len(q.order)
+}
+
+// List 按放入的顺序返回所有死信
+func (q *DeadLetterQueue) List() []DeadLetter {
+	q.mu.Lock()
+	defer q.mu.Unlock()
+	return q.list()
+}
+
+func (q *DeadLetterQueue) list() []DeadLetter {
+	list := make([]DeadLetter, 0, len(q.order))
+	for _, unique := range q.order {
+		list = append(list, *q.letters[unique])
+	}
+	return list
+}
+
+// Drain 取出所有死信并清空队列, 取出和清空在同一次加锁中完成, 不会丢失同时放入的死信
+func (q *DeadLetterQueue) Drain() []DeadLetter {
+	q.mu.Lock()
+	defer q.mu.Unlock()
+	list := q.list()
+	q.letters = make(map[string]*DeadLetter)
+	q.order = nil
+	return list
+}
+
+// Export 以 JSON Lines 格式导出所有死信
+func (q *DeadLetterQueue) Export(w io.Writer) error {
+	enc := json.NewEncoder(w)
+	for _, l := range q.List() {
+		r := deadLetterRecord{
+			requestRecord: newRequestRecord(l.Req),
+			Err:           l.Err,
+			Class:         l.Class,
+			Time:          l.Time,
+		}
+		if err := enc.Encode(r); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// Import 读取 Export 导出的死信, 任务通过 Store 中注册的任务名查找
+func (q *DeadLetterQueue) Import(r io.Reader) error {
+	scanner := bufio.NewScanner(r)
+	scanner.Buffer(make([]byte, 0, 64*1024), 1024*1024)
+	q.mu.Lock()
+	defer q.mu.Unlock()
+	for scanner.Scan() {
+		if len(scanner.Bytes()) == 0 {
+			continue
+		}
+		var record deadLetterRecord
+		if err := json.Unmarshal(scanner.Bytes(), &record); err != nil {
+			return errors.Wrap(err, "decode dead letter error")
+		}
+		req, err := record.request()
+		if err != nil {
+			return err
+		}
+		q.add(&DeadLetter{
+			Req:   req,
+			Err:   record.Err,
+			Class: record.Class,
+			Time:  record.Time,
+		})
+	}
+	return scanner.Err()
+}
+
+// DeadLetters 返回爬虫的死信队列
+func (e *Crawler) DeadLetters() *DeadLetterQueue {
+	return e.deadLetters
+}
+
+// Reinject 将死信队列中的请求清空重试次数后重新放入调度器, 返回放入的请求数
+func (e *Crawler) Reinject() int {
+	letters := e.deadLetters.Drain()
+	reqs := make([]*collect.Request, 0, len(letters))
+	for _, l := range letters {
+		l.Req.Attempt = 0
+		e.reinjected.Store(l.Req, struct{}{})
+		reqs = append(reqs, l.Req)
+	}
+	if len(reqs) > 0 {
+		e.Push(reqs...)
+	}
+	return len(reqs)
+}
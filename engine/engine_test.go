@@ -0,0 +1,529 @@
+package engine_test
+
+import (
+	"bytes"
+	"context"
+	"errors"
+	"fmt"
+	"github.com/funbinary/crawler/collect"
+	"github.com/funbinary/crawler/dedup"
+	"github.com/funbinary/crawler/engine"
+	"github.com/stretchr/testify/require"
+	"os"
+	"path/filepath"
+	"sync/atomic"
+	"testing"
+	"time"
+)
+
+type fakeFetcher struct{}
+
+func (f *fakeFetcher) Get(req *collect.Request) (*collect.Response, error) {
+	return response(req), nil
+}
+
+func response(req *collect.Request) *collect.Response {
+	return &collect.Response{
+		Request:    req,
+		StatusCode: 200,
+		Url:        req.Url,
+		Body:       bytes.Repeat([]byte("a"), 6000),
+	}
+}
+
+func TestRunFinished(t *testing.T) {
+	task := &collect.Task{
+		Property: collect.Property{
+			Name:     "test_run_finished",
+			MaxDepth: 1,
+		},
+		Rule: collect.RuleTree{
+			Root: func() ([]*collect.Request, error) {
+				var roots []*collect.Request
+				for i := 0; i < 3; i++ {
+					roots = append(roots, &collect.Request{
+						Url:      fmt.Sprintf("http://example.com/list/%d", i),
+						Method:   "GET",
+						RuleName: "list",
+					})
+				}
+				return roots, nil
+			},
+			Trunk: map[string]*collect.Rule{
+				"list": {ParseFunc: func(ctx *collect.Context) (collect.ParseResult, error) {
+					return collect.ParseResult{
+						Requesrts: []*collect.Request{{
+							Task:     ctx.Req.Task,
+							Url:      ctx.Req.Url + "/detail",
+							Method:   "GET",
+							Depth:    ctx.Req.Depth + 1,
+							RuleName: "detail",
+						}},
+					}, nil
+				}},
+				"detail": {ParseFunc: func(ctx *collect.Context) (collect.ParseResult, error) {
+					return collect.ParseResult{
+						Items: []*collect.DataCell{ctx.Output(map[string]interface{}{"url": ctx.Req.Url})},
+					}, nil
+				}},
+			},
+		},
+	}
+	engine.Store.Add(task)
+
+	e := engine.NewEngine(
+		engine.WithSeeds([]*collect.Task{{Property: task.Property, Fetcher: &fakeFetcher{}}}),
+		engine.WithWorkCount(2),
+		engine.WithScheduler(engine.NewSchedule()),
+	)
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+	stats, err := e.Run(ctx)
+	require.NoError(t, err)
+	require.Equal(t, int64(6), stats.Requests)
+	require.Equal(t, int64(6), stats.Success)
+	require.Equal(t, int64(3), stats.Items)
+	require.Equal(t, int64(0), stats.Pending)
+}
+
+func TestRunScope(t *testing.T) {
+	var visited []string
+	task := &collect.Task{
+		Property: collect.Property{
+			Name:           "test_run_scope",
+			MaxDepth:       1,
+			AllowedDomains: []string{"example.com"},
+			Exclude:        []string{`/ad/`},
+			MaxPages:       3,
+		},
+		Rule: collect.RuleTree{
+			Root: func() ([]*collect.Request, error) {
+				return []*collect.Request{{Url: "http://www.example.com/list", RuleName: "list"}}, nil
+			},
+			Trunk: map[string]*collect.Rule{
+				"list": {ParseFunc: func(ctx *collect.Context) (collect.ParseResult, error) {
+					var result collect.ParseResult
+					for _, u := range []string{
+						"http://ads.net/click",
+						"http://www.example.com/ad/1",
+						"http://example.com/topic/1",
+						// 重复的请求不占用页面数额度
+						"http://example.com/topic/1",
+						"http://img.example.com/topic/2",
+						"http://example.com/topic/3",
+					} {
+						result.Requesrts = append(result.Requesrts, ctx.Req.Child(u, "detail"))
+					}
+					return result, nil
+				}},
+				"detail": {ParseFunc: func(ctx *collect.Context) (collect.ParseResult, error) {
+					visited = append(visited, ctx.Req.Url)
+					return collect.ParseResult{}, nil
+				}},
+			},
+		},
+	}
+	engine.Store.Add(task)
+
+	e := engine.NewEngine(
+		engine.WithSeeds([]*collect.Task{{Property: task.Property, Fetcher: &fakeFetcher{}}}),
+		engine.WithWorkCount(1),
+		engine.WithScheduler(engine.NewSchedule()),
+	)
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+	stats, err := e.Run(ctx)
+	require.NoError(t, err)
+	require.Equal(t, int64(5), stats.Requests)
+	require.Equal(t, int64(1), stats.Duplicates)
+	require.Equal(t, int64(3), stats.Rejected)
+	require.ElementsMatch(t, []string{"http://example.com/topic/1", "http://img.example.com/topic/2"}, visited)
+}
+
+type failFetcher struct{}
+
+func (f *failFetcher) Get(req *collect.Request) (*collect.Response, error) {
+	return nil, &collect.StatusError{Code: 503}
+}
+
+func TestRunDeadLetter(t *testing.T) {
+	task := &collect.Task{
+		Property: collect.Property{
+			Name: "test_run_dead_letter",
+			Retry: &collect.RetryPolicy{
+				MaxAttempts: 3,
+				BaseDelay:   time.Millisecond,
+			},
+		},
+		Rule: collect.RuleTree{
+			Root: func() ([]*collect.Request, error) {
+				return []*collect.Request{{
+					Url:      "http://example.com/fail",
+					Method:   "GET",
+					RuleName: "list",
+				}}, nil
+			},
+			Trunk: map[string]*collect.Rule{
+				"list": {ParseFunc: func(ctx *collect.Context) (collect.ParseResult, error) {
+					return collect.ParseResult{}, nil
+				}},
+			},
+		},
+	}
+	engine.Store.Add(task)
+
+	e := engine.NewEngine(
+		engine.WithSeeds([]*collect.Task{{Property: task.Property, Fetcher: &failFetcher{}}}),
+		engine.WithWorkCount(1),
+		engine.WithScheduler(engine.NewSchedule()),
+	)
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+	stats, err := e.Run(ctx)
+	require.NoError(t, err)
+	require.Equal(t, int64(2), stats.Retries)
+	require.Equal(t, int64(1), stats.Failures)
+
+	letters := e.DeadLetters().List()
+	require.Len(t, letters, 1)
+	require.Equal(t, 3, letters[0].Req.Attempt)
+	require.Equal(t, string(collect.ErrorStatus), letters[0].Class)
+
+	var buf bytes.Buffer
+	require.NoError(t, e.DeadLetters().Export(&buf))
+	q := engine.NewDeadLetterQueue()
+	require.NoError(t, q.Import(&buf))
+	require.Equal(t, "http://example.com/fail", q.List()[0].Req.Url)
+}
+
+// cancelFetcher 第一次请求详情页时取消爬取
+type cancelFetcher struct {
+	cancel context.CancelFunc
+}
+
+func (f *cancelFetcher) Get(req *collect.Request) (*collect.Response, error) {
+	if f.cancel != nil && req.RuleName == "detail" {
+		f.cancel()
+		return nil, &collect.StatusError{Code: 503}
+	}
+	return response(req), nil
+}
+
+func TestRunResume(t *testing.T) {
+	task := &collect.Task{
+		Property: collect.Property{
+			Name:     "test_run_resume",
+			MaxDepth: 1,
+			Retry:    &collect.RetryPolicy{MaxAttempts: 3, BaseDelay: time.Millisecond},
+		},
+		Rule: collect.RuleTree{
+			Root: func() ([]*collect.Request, error) {
+				return []*collect.Request{{
+					Url:      "http://example.com/list",
+					Method:   "GET",
+					RuleName: "list",
+				}}, nil
+			},
+			Trunk: map[string]*collect.Rule{
+				"list": {ParseFunc: func(ctx *collect.Context) (collect.ParseResult, error) {
+					var result collect.ParseResult
+					for i := 0; i < 3; i++ {
+						result.Requesrts = append(result.Requesrts, &collect.Request{
+							Task:     ctx.Req.Task,
+							Url:      fmt.Sprintf("http://example.com/detail/%d", i),
+							Method:   "GET",
+							Depth:    ctx.Req.Depth + 1,
+							RuleName: "detail",
+						})
+					}
+					return result, nil
+				}},
+				"detail": {ParseFunc: func(ctx *collect.Context) (collect.ParseResult, error) {
+					return collect.ParseResult{
+						Items: []*collect.DataCell{ctx.Output(map[string]interface{}{"url": ctx.Req.Url})},
+					}, nil
+				}},
+			},
+		},
+	}
+	engine.Store.Add(task)
+	dir := t.TempDir()
+
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+	e := engine.NewEngine(
+		engine.WithSeeds([]*collect.Task{{Property: task.Property, Fetcher: &cancelFetcher{cancel: cancel}}}),
+		engine.WithWorkCount(1),
+		engine.WithScheduler(engine.NewSchedule()),
+		engine.WithCheckpoint(dir, "resume", time.Hour),
+		engine.WithResume(),
+	)
+	stats, err := e.Run(ctx)
+	require.ErrorIs(t, err, context.Canceled)
+	require.Equal(t, int64(0), stats.Items)
+
+	ctx, cancel = context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+	e = engine.NewEngine(
+		engine.WithSeeds([]*collect.Task{{Property: task.Property, Fetcher: &cancelFetcher{}}}),
+		engine.WithWorkCount(1),
+		engine.WithScheduler(engine.NewSchedule()),
+		engine.WithCheckpoint(dir, "resume", time.Hour),
+		engine.WithResume(),
+	)
+	stats, err = e.Run(ctx)
+	require.NoError(t, err)
+	require.Equal(t, int64(3), stats.Items)
+	require.Equal(t, int64(4), stats.Success)
+
+	// 已经完成的爬取不会再次执行
+	e = engine.NewEngine(
+		engine.WithSeeds([]*collect.Task{{Property: task.Property, Fetcher: &cancelFetcher{}}}),
+		engine.WithWorkCount(1),
+		engine.WithScheduler(engine.NewSchedule()),
+		engine.WithCheckpoint(dir, "resume", time.Hour),
+		engine.WithResume(),
+	)
+	stats, err = e.Run(ctx)
+	require.NoError(t, err)
+	require.Equal(t, int64(3), stats.Items)
+}
+
+func TestRunResumeMaxPages(t *testing.T) {
+	task := &collect.Task{
+		Property: collect.Property{
+			Name:     "test_run_resume_max_pages",
+			MaxDepth: 1,
+			MaxPages: 2,
+			Retry:    &collect.RetryPolicy{MaxAttempts: 3, BaseDelay: time.Millisecond},
+		},
+		Rule: collect.RuleTree{
+			Root: func() ([]*collect.Request, error) {
+				return []*collect.Request{{Url: "http://example.com/list", Method: "GET", RuleName: "list"}}, nil
+			},
+			Trunk: map[string]*collect.Rule{
+				"list": {ParseFunc: func(ctx *collect.Context) (collect.ParseResult, error) {
+					var result collect.ParseResult
+					for i := 0; i < 3; i++ {
+						result.Requesrts = append(result.Requesrts, ctx.Req.Child(fmt.Sprintf("http://example.com/detail/%d", i), "detail"))
+					}
+					return result, nil
+				}},
+				"detail": {ParseFunc: func(ctx *collect.Context) (collect.ParseResult, error) {
+					return collect.ParseResult{
+						Items: []*collect.DataCell{ctx.Output(map[string]interface{}{"url": ctx.Req.Url})},
+					}, nil
+				}},
+			},
+		},
+	}
+	engine.Store.Add(task)
+	dir := t.TempDir()
+
+	// 第一次运行抓取了列表页和一个详情页, 用尽了页面数额度
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+	e := engine.NewEngine(
+		engine.WithSeeds([]*collect.Task{{Property: task.Property, Fetcher: &cancelFetcher{cancel: cancel}}}),
+		engine.WithWorkCount(1),
+		engine.WithScheduler(engine.NewSchedule()),
+		engine.WithCheckpoint(dir, "resume_max_pages", time.Hour),
+		engine.WithResume(),
+	)
+	_, err := e.Run(ctx)
+	require.ErrorIs(t, err, context.Canceled)
+
+	// 恢复后只重试已经占用额度的请求
+	ctx, cancel = context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+	e = engine.NewEngine(
+		engine.WithSeeds([]*collect.Task{{Property: task.Property, Fetcher: &cancelFetcher{}}}),
+		engine.WithWorkCount(1),
+		engine.WithScheduler(engine.NewSchedule()),
+		engine.WithCheckpoint(dir, "resume_max_pages", time.Hour),
+		engine.WithResume(),
+	)
+	stats, err := e.Run(ctx)
+	require.NoError(t, err)
+	require.Equal(t, int64(1), stats.Items)
+	require.Equal(t, int64(2), stats.Rejected)
+}
+
+// reinjectFetcher 第一次请求 fail 时失败, 请求 wait 时将死信重新放入调度器
+type reinjectFetcher struct {
+	engine *engine.Crawler
+	failed atomic.Bool
+}
+
+func (f *reinjectFetcher) Get(req *collect.Request) (*collect.Response, error) {
+	switch req.Url {
+	case "http://example.com/fail":
+		if f.failed.CompareAndSwap(false, true) {
+			return nil, &collect.StatusError{Code: 503}
+		}
+	case "http://example.com/wait":
+		if f.engine.Reinject() != 1 {
+			return nil, errors.New("no dead letter")
+		}
+	}
+	return response(req), nil
+}
+
+func TestRunReinject(t *testing.T) {
+	task := &collect.Task{
+		Property: collect.Property{
+			Name:  "test_run_reinject",
+			Retry: &collect.RetryPolicy{MaxAttempts: 1},
+		},
+		Rule: collect.RuleTree{
+			Root: func() ([]*collect.Request, error) {
+				return []*collect.Request{
+					{Url: "http://example.com/fail", Method: "GET", RuleName: "list"},
+					{Url: "http://example.com/wait", Method: "GET", RuleName: "list"},
+				}, nil
+			},
+			Trunk: map[string]*collect.Rule{
+				"list": {ParseFunc: func(ctx *collect.Context) (collect.ParseResult, error) {
+					return collect.ParseResult{}, nil
+				}},
+			},
+		},
+	}
+	engine.Store.Add(task)
+
+	// 布隆过滤器不支持删除, 重新放入的死信也不能被当作重复的请求丢弃
+	f := &reinjectFetcher{}
+	e := engine.NewEngine(
+		engine.WithSeeds([]*collect.Task{{Property: task.Property, Fetcher: f}}),
+		engine.WithWorkCount(1),
+		engine.WithScheduler(engine.NewSchedule()),
+		engine.WithDeduper(dedup.NewBloomDeduper(1000, 0.001)),
+	)
+	f.engine = e
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+	stats, err := e.Run(ctx)
+	require.NoError(t, err)
+	require.Equal(t, int64(2), stats.Success)
+	require.Equal(t, int64(0), stats.Duplicates)
+	require.Empty(t, e.DeadLetters().List())
+}
+
+// slowFetcher 请求详情页时取消爬取, 并一直等待到请求的 Context 被取消
+type slowFetcher struct {
+	cancel context.CancelFunc
+	active atomic.Int64 // 正在进行的请求数
+}
+
+func (f *slowFetcher) Get(req *collect.Request) (*collect.Response, error) {
+	f.active.Add(1)
+	defer f.active.Add(-1)
+	if req.RuleName == "detail" {
+		f.cancel()
+		<-req.Context().Done()
+		return nil, req.Context().Err()
+	}
+	return response(req), nil
+}
+
+// closedStorage 记录关闭之后的写入
+type closedStorage struct {
+	closed     atomic.Bool
+	afterClose atomic.Int64
+}
+
+func (s *closedStorage) Save(datas ...*collect.DataCell) error {
+	if s.closed.Load() {
+		s.afterClose.Add(1)
+	}
+	return nil
+}
+
+func (s *closedStorage) Flush() error {
+	if s.closed.Load() {
+		s.afterClose.Add(1)
+	}
+	return nil
+}
+
+func (s *closedStorage) Close() error {
+	s.closed.Store(true)
+	return nil
+}
+
+func TestRunShutdownTimeout(t *testing.T) {
+	task := &collect.Task{
+		Property: collect.Property{Name: "test_run_shutdown_timeout", MaxDepth: 1},
+		Rule: collect.RuleTree{
+			Root: func() ([]*collect.Request, error) {
+				return []*collect.Request{{Url: "http://example.com/list", Method: "GET", RuleName: "list"}}, nil
+			},
+			Trunk: map[string]*collect.Rule{
+				"list": {ParseFunc: func(ctx *collect.Context) (collect.ParseResult, error) {
+					return collect.ParseResult{Requesrts: []*collect.Request{ctx.Req.Child("http://example.com/detail", "detail")}}, nil
+				}},
+				"detail": {ParseFunc: func(ctx *collect.Context) (collect.ParseResult, error) {
+					return collect.ParseResult{
+						Items: []*collect.DataCell{ctx.Output(map[string]interface{}{"url": ctx.Req.Url})},
+					}, nil
+				}},
+			},
+		},
+	}
+	engine.Store.Add(task)
+	dir := t.TempDir()
+
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+	f := &slowFetcher{cancel: cancel}
+	store := &closedStorage{}
+	e := engine.NewEngine(
+		engine.WithSeeds([]*collect.Task{{Property: task.Property, Fetcher: f}}),
+		engine.WithWorkCount(1),
+		engine.WithScheduler(engine.NewSchedule()),
+		engine.WithStorage(store),
+		engine.WithShutdownTimeout(50*time.Millisecond),
+		engine.WithCheckpoint(dir, "shutdown", time.Hour),
+	)
+	stats, err := e.Run(ctx)
+	require.ErrorContains(t, err, "shutdown timeout")
+	// 返回前已经中止正在进行的请求并等待 worker 退出
+	require.Zero(t, f.active.Load())
+	require.NoError(t, store.Close())
+	time.Sleep(50 * time.Millisecond)
+	require.Zero(t, store.afterClose.Load())
+
+	// 被中止的请求不算失败, 不会放入死信队列
+	require.Zero(t, stats.Failures)
+	require.Zero(t, stats.Retries)
+	require.Empty(t, e.DeadLetters().List())
+
+	// 超时放弃的请求保存在断点中
+	b, err := os.ReadFile(filepath.Join(dir, "shutdown", "checkpoint.json"))
+	require.NoError(t, err)
+	require.Contains(t, string(b), "http://example.com/detail")
+}
+
+func TestDeadLetterDrain(t *testing.T) {
+	q := engine.NewDeadLetterQueue()
+	const n = 2000
+	done := make(chan struct{})
+	go func() {
+		defer close(done)
+		for i := 0; i < n; i++ {
+			q.Add(&collect.Request{Url: fmt.Sprintf("http://example.com/%d", i)}, errors.New("fail"))
+		}
+	}()
+
+	// 与 Add 同时进行的 Drain 不会丢失死信
+	drained := 0
+	for running := true; running; {
+		select {
+		case <-done:
+			running = false
+		default:
+		}
+		drained += len(q.Drain())
+	}
+	require.Equal(t, n, drained)
+}
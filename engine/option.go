@@ -2,7 +2,11 @@ package engine
 
 import (
 	"github.com/funbinary/crawler/collect"
+	"github.com/funbinary/crawler/dedup"
+	"github.com/funbinary/crawler/robots"
+	"github.com/funbinary/crawler/storage"
 	"go.uber.org/zap"
+	"time"
 )
 
 type Option func(option *options)
@@ -13,10 +17,27 @@ type options struct {
 	Logger    *zap.Logger
 	Seeds     []*collect.Task
 	scheduler Scheduler
+	Storage   storage.Storage // 为空时只将结果打印到日志中
+	HostLimit HostLimit       // 所有任务对每个站点的访问限制
+	Robots    *robots.Manager // 开启 RobotsTxt 的任务使用, 为空时使用默认配置, 默认配置不经过代理和 NetworkGuard
+	Deduper   dedup.Deduper   // 任务未指定去重器时使用, 为空时使用内存去重
+	// 断点保存在 CheckpointDir/CrawlName 目录中, CheckpointDir 为空时不保存
+	CheckpointDir      string
+	CrawlName          string
+	CheckpointInterval time.Duration
+	Resume             bool // 从断点恢复爬取
+	// 检测到封禁页面后暂停访问该站点的时间, 重试时会经过其它代理
+	BlockCooldown time.Duration
+	// 停止时等待正在处理的请求完成的最长时间
+	ShutdownTimeout time.Duration
 }
 
 var defaultOptions = options{
-	Logger: zap.NewNop(),
+	Logger:             zap.NewNop(),
+	ShutdownTimeout:    30 * time.Second,
+	CrawlName:          "default",
+	CheckpointInterval: time.Minute,
+	BlockCooldown:      30 * time.Second,
 }
 
 func WithLogger(logger *zap.Logger) Option {
@@ -47,3 +68,55 @@ func WithScheduler(s Scheduler) Option {
 		opts.scheduler = s
 	}
 }
+
+func WithShutdownTimeout(timeout time.Duration) Option {
+	return func(opts *options) {
+		opts.ShutdownTimeout = timeout
+	}
+}
+
+func WithStorage(s storage.Storage) Option {
+	return func(opts *options) {
+		opts.Storage = s
+	}
+}
+
+func WithHostLimit(limit HostLimit) Option {
+	return func(opts *options) {
+		opts.HostLimit = limit
+	}
+}
+
+func WithRobots(m *robots.Manager) Option {
+	return func(opts *options) {
+		opts.Robots = m
+	}
+}
+
+func WithDeduper(d dedup.Deduper) Option {
+	return func(opts *options) {
+		opts.Deduper = d
+	}
+}
+
+// WithCheckpoint 每隔 interval 将名为 name 的爬取的进度保存到 dir/name 目录中, 退出时也会保存一次
+func WithCheckpoint(dir string, name string, interval time.Duration) Option {
+	return func(opts *options) {
+		opts.CheckpointDir = dir
+		opts.CrawlName = name
+		opts.CheckpointInterval = interval
+	}
+}
+
+// WithResume 从 WithCheckpoint 设置的断点继续爬取, 断点不存在时重新开始
+func WithResume() Option {
+	return func(opts *options) {
+		opts.Resume = true
+	}
+}
+
+func WithBlockCooldown(d time.Duration) Option {
+	return func(opts *options) {
+		opts.BlockCooldown = d
+	}
+}
@@ -0,0 +1,135 @@
+package engine
+
+import (
+	"github.com/funbinary/crawler/collect"
+	"net/url"
+	"sync"
+	"time"
+)
+
+// HostLimit 对单个站点的访问限制, 零值表示不限制
+type HostLimit struct {
+	RPS           float64 // 每秒最多请求数
+	MaxConcurrent int     // 同时进行的最大请求数
+}
+
+// merge 取两个限制中更严格的一个
+func (l HostLimit) merge(o HostLimit) HostLimit {
+	if o.RPS > 0 && (l.RPS == 0 || o.RPS < l.RPS) {
+		l.RPS = o.RPS
+	}
+	if o.MaxConcurrent > 0 && (l.MaxConcurrent == 0 || o.MaxConcurrent < l.MaxConcurrent) {
+		l.MaxConcurrent = o.MaxConcurrent
+	}
+	return l
+}
+
+func (l HostLimit) interval() time.Duration {
+	if l.RPS <= 0 {
+		return 0
+	}
+	return time.Duration(float64(time.Second) / l.RPS)
+}
+
+// TaskHostLimit 任务对每个站点的访问限制
+func TaskHostLimit(task *collect.Task) HostLimit {
+	limit := HostLimit{
+		RPS:           task.RateLimit,
+		MaxConcurrent: task.MaxConcurrent,
+	}
+	if task.WaitTime > 0 {
+		limit = limit.merge(HostLimit{RPS: float64(time.Second) / float64(task.WaitTime)})
+	}
+	return limit
+}
+
+type hostState struct {
+	next   time.Time     // 下一次允许请求的时间
+	active int           // 正在进行的请求数
+	delay  time.Duration // 站点要求的最小请求间隔, 如 robots.txt 中的 Crawl-delay
+}
+
+// Politeness 按站点限制请求频率和并发数。
+// 站点暂时不能访问时, worker 不会阻塞等待, 而是延迟该请求, 转而处理其它站点的请求。
+type Politeness struct {
+	mu    sync.Mutex
+	hosts map[string]*hostState
+}
+
+func NewPoliteness() *Politeness {
+	return &Politeness{
+		hosts: make(map[string]*hostState),
+	}
+}
+
+func (p *Politeness) state(host string) *hostState {
+	s, ok := p.hosts[host]
+	if !ok {
+		s = &hostState{}
+		p.hosts[host] = s
+	}
+	return s
+}
+
+// Acquire 尝试获取访问 host 的许可。
+// 成功时返回 0, 请求结束后需要调用 Release; 否则返回需要等待的时间。
+func (p *Politeness) Acquire(host string, limit HostLimit) time.Duration {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	s := p.state(host)
+	now := time.Now()
+	if wait := s.next.Sub(now); wait > 0 {
+		return wait
+	}
+	if limit.MaxConcurrent > 0 && s.active >= limit.MaxConcurrent {
+		// 无法预知其它请求何时结束, 稍后再试
+		interval := limit.interval()
+		if interval <= 0 || interval > time.Second {
+			interval = 100 * time.Millisecond
+		}
+		return interval
+	}
+	s.active++
+	interval := limit.interval()
+	if s.delay > interval {
+		interval = s.delay
+	}
+	s.next = now.Add(interval)
+	return 0
+}
+
+// Release 释放 Acquire 获取的许可
+func (p *Politeness) Release(host string) {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	s := p.state(host)
+	if s.active > 0 {
+		s.active--
+	}
+}
+
+// Cooldown 在 d 时间内暂停访问 host, 用于处理 Retry-After
+func (p *Politeness) Cooldown(host string, d time.Duration) {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	s := p.state(host)
+	if until := time.Now().Add(d); until.After(s.next) {
+		s.next = until
+	}
+}
+
+// SetDelay 设置站点要求的最小请求间隔
+func (p *Politeness) SetDelay(host string, d time.Duration) {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	p.state(host).delay = d
+}
+
+// hostOf 请求的站点, 解析失败时返回原始地址
+func hostOf(rawUrl string) string {
+	u, err := url.Parse(rawUrl)
+	if err != nil {
+		return rawUrl
+	}
+	return u.Host
+}
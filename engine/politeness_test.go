@@ -0,0 +1,172 @@
+package engine_test
+
+import (
+	"context"
+	"fmt"
+	"github.com/funbinary/crawler/collect"
+	"github.com/funbinary/crawler/engine"
+	"github.com/stretchr/testify/require"
+	"net/http"
+	"net/url"
+	"sync"
+	"testing"
+	"time"
+)
+
+// politeFetcher 记录每个站点的请求时间和同时进行的最大请求数
+type politeFetcher struct {
+	mu        sync.Mutex
+	sleep     time.Duration
+	status    map[string]int // 站点 -> 第一次请求返回的状态码
+	times     map[string][]time.Time
+	active    map[string]int
+	maxActive map[string]int
+	total     int
+	maxTotal  int
+}
+
+func newPoliteFetcher(sleep time.Duration) *politeFetcher {
+	return &politeFetcher{
+		sleep:     sleep,
+		status:    make(map[string]int),
+		times:     make(map[string][]time.Time),
+		active:    make(map[string]int),
+		maxActive: make(map[string]int),
+	}
+}
+
+func (f *politeFetcher) Get(req *collect.Request) (*collect.Response, error) {
+	u, err := url.Parse(req.Url)
+	if err != nil {
+		return nil, err
+	}
+	host := u.Host
+	f.mu.Lock()
+	f.times[host] = append(f.times[host], time.Now())
+	f.active[host]++
+	f.total++
+	if f.active[host] > f.maxActive[host] {
+		f.maxActive[host] = f.active[host]
+	}
+	if f.total > f.maxTotal {
+		f.maxTotal = f.total
+	}
+	status := f.status[host]
+	delete(f.status, host)
+	f.mu.Unlock()
+
+	time.Sleep(f.sleep)
+
+	f.mu.Lock()
+	f.active[host]--
+	f.total--
+	f.mu.Unlock()
+
+	resp := response(req)
+	if status != 0 {
+		resp.StatusCode = status
+		resp.Header = http.Header{"Retry-After": {"1"}}
+	}
+	return resp, nil
+}
+
+// politeTask 依次请求每个站点的 n 个页面
+func politeTask(name string, hosts []string, n int) *collect.Task {
+	return &collect.Task{
+		Property: collect.Property{
+			Name:  name,
+			Retry: &collect.RetryPolicy{MaxAttempts: 2, BaseDelay: time.Millisecond},
+		},
+		Rule: collect.RuleTree{
+			Root: func() ([]*collect.Request, error) {
+				var reqs []*collect.Request
+				for _, host := range hosts {
+					for i := 0; i < n; i++ {
+						reqs = append(reqs, &collect.Request{
+							Url:      fmt.Sprintf("http://%s/%d", host, i),
+							Method:   "GET",
+							RuleName: "page",
+						})
+					}
+				}
+				return reqs, nil
+			},
+			Trunk: map[string]*collect.Rule{
+				"page": {ParseFunc: func(ctx *collect.Context) (collect.ParseResult, error) {
+					return collect.ParseResult{}, nil
+				}},
+			},
+		},
+	}
+}
+
+func runPolite(t *testing.T, task *collect.Task, f *politeFetcher, opts ...engine.Option) engine.Stats {
+	engine.Store.Add(task)
+	opts = append([]engine.Option{
+		engine.WithSeeds([]*collect.Task{{Property: task.Property, Fetcher: f}}),
+		engine.WithScheduler(engine.NewSchedule()),
+	}, opts...)
+	ctx, cancel := context.WithTimeout(context.Background(), 10*time.Second)
+	defer cancel()
+	stats, err := engine.NewEngine(opts...).Run(ctx)
+	require.NoError(t, err)
+	return stats
+}
+
+func TestRunHostRPS(t *testing.T) {
+	task := politeTask("test_run_host_rps", []string{"a.com", "b.com"}, 4)
+	// 任务的限制更严格, 每个站点的请求间隔至少 50ms
+	task.RateLimit = 20
+	f := newPoliteFetcher(0)
+	stats := runPolite(t, task, f,
+		engine.WithWorkCount(4),
+		engine.WithHostLimit(engine.HostLimit{RPS: 100}),
+	)
+	require.Equal(t, int64(8), stats.Success)
+
+	for _, host := range []string{"a.com", "b.com"} {
+		times := f.times[host]
+		require.Len(t, times, 4)
+		for i := 1; i < len(times); i++ {
+			require.GreaterOrEqual(t, times[i].Sub(times[i-1]), 45*time.Millisecond, host)
+		}
+	}
+}
+
+func TestRunMaxConcurrent(t *testing.T) {
+	task := politeTask("test_run_max_concurrent", []string{"a.com", "b.com"}, 6)
+	task.MaxConcurrent = 2
+	f := newPoliteFetcher(30 * time.Millisecond)
+	stats := runPolite(t, task, f, engine.WithWorkCount(6))
+	require.Equal(t, int64(12), stats.Success)
+
+	require.Equal(t, 2, f.maxActive["a.com"])
+	require.Equal(t, 2, f.maxActive["b.com"])
+	// 限制只针对单个站点, 不同站点的请求可以同时进行
+	require.Greater(t, f.maxTotal, 2)
+}
+
+func TestRunRetryAfterCooldown(t *testing.T) {
+	task := politeTask("test_run_retry_after", []string{"a.com", "b.com", "c.com"}, 3)
+	f := newPoliteFetcher(0)
+	// a.com 和 c.com 第一次请求时要求 1 秒后再试
+	f.status["a.com"] = http.StatusTooManyRequests
+	f.status["c.com"] = http.StatusServiceUnavailable
+	stats := runPolite(t, task, f, engine.WithWorkCount(1))
+	require.Equal(t, int64(9), stats.Success)
+	require.Equal(t, int64(2), stats.Retries)
+
+	// 冷却期间不会访问该站点, 包括其它请求
+	for _, host := range []string{"a.com", "c.com"} {
+		times := f.times[host]
+		require.Len(t, times, 4)
+		for _, tm := range times[1:] {
+			require.GreaterOrEqual(t, tm.Sub(times[0]), 950*time.Millisecond, host)
+		}
+	}
+	// 其它站点的请求不会等待冷却中的站点
+	start := f.times["a.com"][0]
+	for _, tm := range f.times["b.com"] {
+		require.Less(t, tm.Sub(start), 500*time.Millisecond)
+	}
+}
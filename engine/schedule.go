@@ -1,12 +1,20 @@
 package engine
 
 import (
+	"context"
+	"fmt"
 	"github.com/funbinary/crawler/collect"
+	"github.com/funbinary/crawler/dedup"
 	"github.com/funbinary/crawler/parse/doubangroup"
+	"github.com/funbinary/crawler/robots"
+	"github.com/pkg/errors"
 	"github.com/robertkrimen/otto"
 	"go.uber.org/zap"
-
+	"net/http"
+	"net/url"
 	"sync"
+	"sync/atomic"
+	"time"
 )
 
 func init() {
@@ -39,15 +47,10 @@ func AddJsReqs(jreqs []map[string]interface{}) []*collect.Request {
 	reqs := make([]*collect.Request, 0)
 
 	for _, jreq := range jreqs {
-		req := &collect.Request{}
-		u, ok := jreq["Url"].(string)
-		if !ok {
+		req := jsRequest(jreq)
+		if req == nil {
 			return nil
 		}
-		req.Url = u
-		req.RuleName, _ = jreq["RuleName"].(string)
-		req.Method, _ = jreq["Method"].(string)
-		req.Priority, _ = jreq["Priority"].(int64)
 		reqs = append(reqs, req)
 	}
 	return reqs
@@ -55,18 +58,76 @@ func AddJsReqs(jreqs []map[string]interface{}) []*collect.Request {
 
 // 用于动态规则添加请求。
 func AddJsReq(jreq map[string]interface{}) []*collect.Request {
-	reqs := make([]*collect.Request, 0)
-	req := &collect.Request{}
+	req := jsRequest(jreq)
+	if req == nil {
+		return nil
+	}
+	return []*collect.Request{req}
+}
+
+// jsRequest 将动态规则中的对象转换为请求, 缺少 Url 时返回 nil
+func jsRequest(jreq map[string]interface{}) *collect.Request {
 	u, ok := jreq["Url"].(string)
 	if !ok {
 		return nil
 	}
-	req.Url = u
+	req := &collect.Request{Url: u}
 	req.RuleName, _ = jreq["RuleName"].(string)
 	req.Method, _ = jreq["Method"].(string)
-	req.Priority, _ = jreq["Priority"].(int64)
-	reqs = append(reqs, req)
-	return reqs
+	req.Priority = jsInt(jreq["Priority"])
+	req.Depth = jsInt(jreq["Depth"])
+	if h := jsValues(jreq["Header"]); h != nil {
+		req.Header = http.Header{}
+		for k, vs := range h {
+			for _, v := range vs {
+				req.Header.Add(k, v)
+			}
+		}
+	}
+	req.Query = jsValues(jreq["Query"])
+	req.Form = jsValues(jreq["Form"])
+	req.JSON = jreq["JSON"]
+	if body, ok := jreq["Body"].(string); ok {
+		req.Body = []byte(body)
+	}
+	req.Meta, _ = jreq["Meta"].(map[string]interface{})
+	return req
+}
+
+func jsInt(v interface{}) int64 {
+	switch n := v.(type) {
+	case int64:
+		return n
+	case int:
+		return int64(n)
+	case float64:
+		return int64(n)
+	}
+	return 0
+}
+
+// jsValues 将动态规则中的对象转换为 url.Values, 值可以是字符串或字符串数组
+func jsValues(v interface{}) url.Values {
+	m, ok := v.(map[string]interface{})
+	if !ok {
+		return nil
+	}
+	values := url.Values{}
+	for k, val := range m {
+		switch vv := val.(type) {
+		case []interface{}:
+			for _, item := range vv {
+				values.Add(k, fmt.Sprint(item))
+			}
+		case []string:
+			for _, item := range vv {
+				values.Add(k, item)
+			}
+		default:
+			values.Add(k, fmt.Sprint(vv))
+		}
+	}
+	return values
 }
 
 func (c *CrawlerStore) AddJSTask(m *collect.TaskModle) {
@@ -88,6 +149,23 @@ func (c *CrawlerStore) AddJSTask(m *collect.TaskModle) {
 		return e.([]*collect.Request), nil
 	}
 
+	if m.Login != "" {
+		task.Login = func(ctx *collect.LoginContext) error {
+			vm := otto.New()
+			vm.Set("ctx", jsLoginContext(vm, ctx))
+			v, err := vm.Eval(m.Login)
+			if err != nil {
+				return err
+			}
+			if v.IsBoolean() {
+				if ok, _ := v.ToBoolean(); !ok {
+					return errors.New("login script returned false")
+				}
+			}
+			return nil
+		}
+	}
+
 	for _, r := range m.Rules {
 		paesrFunc := func(parse string) func(ctx *collect.Context) (collect.ParseResult, error) {
 			return func(ctx *collect.Context) (collect.ParseResult, error) {
@@ -111,7 +189,8 @@ func (c *CrawlerStore) AddJSTask(m *collect.TaskModle) {
 			task.Rule.Trunk = make(map[string]*collect.Rule, 0)
 		}
 		task.Rule.Trunk[r.Name] = &collect.Rule{
-			paesrFunc,
+			ParseFunc:  paesrFunc,
+			ItemFields: r.ItemFields,
 		}
 	}
 
@@ -119,12 +198,46 @@ func (c *CrawlerStore) AddJSTask(m *collect.TaskModle) {
 	c.list = append(c.list, task)
 }
 
+// jsLoginContext 登录脚本中的 ctx, 请求失败时抛出异常
+func jsLoginContext(vm *otto.Otto, ctx *collect.LoginContext) map[string]interface{} {
+	check := func(resp *collect.Response, err error) *collect.Response {
+		if err != nil {
+			panic(vm.MakeCustomError("LoginError", err.Error()))
+		}
+		return resp
+	}
+	return map[string]interface{}{
+		"Get": func(u string) *collect.Response {
+			return check(ctx.Get(u))
+		},
+		"PostForm": func(u string, form map[string]interface{}) *collect.Response {
+			return check(ctx.PostFormJS(u, form))
+		},
+		"PostJSON": func(u string, body interface{}) *collect.Response {
+			return check(ctx.PostJSON(u, body))
+		},
+		"Cookie": ctx.Cookie,
+	}
+}
+
 type Crawler struct {
-	out         chan collect.ParseResult //负责处理爬取后的数据，完成下一步的存储操作。schedule 函数会创建调度程序，负责的是调度的核心逻辑。
-	Visited     map[string]bool          //存储请求访问信息
-	VisitedLock sync.Mutex
-	failures    map[string]*collect.Request // 失败请求id -> 失败请求
-	failureLock sync.Mutex
+	out     chan collect.ParseResult //负责处理爬取后的数据，完成下一步的存储操作。schedule 函数会创建调度程序，负责的是调度的核心逻辑。
+	abandon chan struct{}            // 关闭超时后关闭, 不再处理正在进行的请求产生的结果
+	// 传递给抓取器的 context, 关闭超时后取消, 中止正在进行的请求
+	fetchCtx    context.Context
+	stopFetch   context.CancelFunc
+	deadLetters *DeadLetterQueue // 重试次数用尽后仍然失败的请求
+	politeness  *Politeness      // 按站点限制请求频率和并发数
+	stats       counter
+	// 尚未处理完成的请求及其被放入调度器的次数, 用于保存断点
+	outstanding     map[*collect.Request]int
+	outstandingLock sync.Mutex
+	resumed         sync.Map    // 从断点恢复的请求
+	reinjected      sync.Map    // 从死信队列重新放入的请求, 已经访问过并占用过页面数额度
+	pages           sync.Map    // 任务名 -> 已经抓取的页面数, 用于限制 MaxPages
+	checkpointLock  sync.Mutex  // 保证同时只有一个断点在保存
+	finished        atomic.Bool // 所有请求均已处理完成
+	finish          context.CancelFunc
 	options
 }
 
@@ -134,166 +247,556 @@ func NewEngine(opts ...Option) *Crawler {
 		opt(&options)
 	}
 	e := &Crawler{}
-	e.Visited = make(map[string]bool, 100)
-	e.failures = make(map[string]*collect.Request)
+	e.out = make(chan collect.ParseResult)
+	e.abandon = make(chan struct{})
+	e.fetchCtx, e.stopFetch = context.WithCancel(context.Background())
+	e.deadLetters = NewDeadLetterQueue()
+	e.politeness = NewPoliteness()
+	e.outstanding = make(map[*collect.Request]int)
 	e.options = options
+	if e.Robots == nil {
+		e.Robots = robots.NewManager()
+	}
+	if e.Deduper == nil {
+		e.Deduper = dedup.NewMemoryDeduper()
+	}
 	return e
 }
 
-func (e *Crawler) Run() {
-	go e.Schedule()
+// Run 启动调度器和 worker, 阻塞直到所有请求处理完成或 ctx 被取消。
+// 请求全部处理完成时返回统计信息和 nil。
+// ctx 取消后不再分发新的请求, 等待正在处理的请求在 ShutdownTimeout 内完成,
+// 并处理完所有已产生的结果后返回。超时后取消请求的 Context 中止仍在处理的请求,
+// 等待所有 worker 退出后保存断点并返回, 被中止的请求保存在断点中, 恢复后重新抓取。
+// 因此 Fetcher 需要在请求的 Context 取消后尽快返回。
+func (e *Crawler) Run(ctx context.Context) (Stats, error) {
+	ctx, cancel := context.WithCancel(ctx)
+	defer cancel()
+	defer e.stopFetch()
+	e.finish = cancel
+	e.stats.start = time.Now()
+
+	if err := e.Schedule(ctx); err != nil {
+		return e.Stats(), err
+	}
+	if e.CheckpointDir != "" && e.CheckpointInterval > 0 {
+		go e.runCheckpoint(ctx)
+	}
 	// 创建指定数量的 worker，完成实际任务的处理
-	// 其中
+	var wg sync.WaitGroup
 	for i := 0; i < e.WorkCount; i++ {
-		go e.CreateWork()
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			e.CreateWork()
+		}()
+	}
+	// 所有 worker 退出后关闭 out, HandleResult 处理完剩余结果后返回
+	go func() {
+		wg.Wait()
+		close(e.out)
+	}()
+
+	done := make(chan struct{})
+	go func() {
+		defer close(done)
+		e.HandleResult()
+	}()
+
+	<-ctx.Done()
+	if e.finished.Load() {
+		e.Logger.Info("crawl finished")
+	} else {
+		e.Logger.Info("crawler shutting down",
+			zap.Duration("timeout", e.ShutdownTimeout),
+		)
+	}
+
+	var timeoutErr error
+	timer := time.NewTimer(e.ShutdownTimeout)
+	defer timer.Stop()
+	select {
+	case <-done:
+	case <-timer.C:
+		// 中止正在进行的请求, 等待 worker 退出和 HandleResult 写入缓存的数据,
+		// 返回后不会再修改去重器、死信队列和 Storage
+		close(e.abandon)
+		e.stopFetch()
+		wg.Wait()
+		<-done
+		timeoutErr = errors.Errorf("crawler shutdown timeout after %v, in-flight requests abandoned", e.ShutdownTimeout)
+	}
+
+	if e.CheckpointDir != "" {
+		if err := e.Checkpoint(); err != nil {
+			e.Logger.Error("save checkpoint failed", zap.Error(err))
+		}
+	}
+
+	stats := e.Stats()
+	e.Logger.Info("crawler stats", zap.Any("stats", stats))
+	if timeoutErr != nil {
+		return stats, timeoutErr
 	}
-	e.HandleResult()
+	if e.finished.Load() {
+		return stats, nil
+	}
+	return stats, errors.Wrapf(ctx.Err(), "crawler stopped, %d failed requests, %d pending requests", stats.Failures, stats.Pending)
 }
 
-// Schedule 从seed种子任务添加到任务列表中, 并启动调度
-func (e *Crawler) Schedule() {
-	// workerCh
-	var reqs []*collect.Request
+// Schedule 从seed种子任务添加到任务列表中, 并启动调度。
+// 从断点恢复时, 使用断点中尚未完成的请求代替种子任务的根请求。
+func (e *Crawler) Schedule(ctx context.Context) error {
+	var tasks []*collect.Task
 	for _, seed := range e.Seeds {
-		task := Store.hash[seed.Name]
-		task.Fetcher = seed.Fetcher
-		rootreqs, err := task.Rule.Root()
+		task, ok := Store.hash[seed.Name]
+		if !ok {
+			return errors.Errorf("task %s not found", seed.Name)
+		}
+		task.Fetcher = collect.Chain(seed.Fetcher, task.Middlewares...)
+		tasks = append(tasks, task)
+	}
+
+	var reqs []*collect.Request
+	resumed := false
+	if e.Resume && e.CheckpointDir != "" {
+		cp, restored, err := e.restore()
 		if err != nil {
-			e.Logger.Error("get root failed",
+			return errors.Wrap(err, "resume from checkpoint error")
+		}
+		if cp != nil {
+			e.Logger.Info("resume from checkpoint",
+				zap.Time("time", cp.Time),
+				zap.Int("requests", len(restored)),
+			)
+			reqs, resumed = restored, true
+			if cp.Finished {
+				e.Logger.Info("crawl already finished")
+			}
+		}
+	}
+	if !resumed {
+		for _, task := range tasks {
+			rootreqs, err := task.Rule.Root()
+			if err != nil {
+				e.Logger.Error("get root failed",
+					zap.Error(err),
+				)
+				continue
+			}
+			for _, req := range rootreqs {
+				req.Task = task
+			}
+			reqs = append(reqs, rootreqs...)
+		}
+	}
+
+	if !resumed {
+		reqs = e.admit(reqs)
+	}
+
+	go e.scheduler.Schedule(ctx)
+	if len(reqs) == 0 {
+		e.Logger.Warn("no requests to schedule")
+		e.finished.Store(true)
+		e.finish()
+		return nil
+	}
+	if resumed {
+		// 恢复的请求已经计入统计信息
+		e.stats.pending.Add(int64(len(reqs)))
+		e.track(reqs...)
+		go e.scheduler.Push(reqs...)
+		return nil
+	}
+	e.enqueue(reqs)
+	return nil
+}
+
+// Push 将抓取范围内的请求放入调度器, 并计入待处理的请求数
+func (e *Crawler) Push(reqs ...*collect.Request) {
+	e.enqueue(e.admit(reqs))
+}
+
+// admit 规范化请求的 URL, 丢弃超出任务抓取范围或页面数上限的请求
+func (e *Crawler) admit(reqs []*collect.Request) []*collect.Request {
+	admitted := make([]*collect.Request, 0, len(reqs))
+	for _, req := range reqs {
+		if err := req.Normalize(); err != nil {
+			e.Logger.Warn("normalize url failed",
 				zap.Error(err),
+				zap.String("url", req.Url),
 			)
+		}
+		if req.Task != nil && !req.Task.InScope(req.Url) {
+			e.stats.rejected.Add(1)
+			e.Logger.Debug("request out of scope", zap.String("url", req.Url))
 			continue
 		}
-		for _, req := range rootreqs {
-			req.Task = task
+		// 额度在抓取时才占用, 这里只丢弃额度已经用尽的任务的请求
+		if e.pagesExhausted(req.Task) {
+			e.rejectPage(req)
+			continue
 		}
-		reqs = append(reqs, rootreqs...)
+		admitted = append(admitted, req)
+	}
+	return admitted
+}
+
+func (e *Crawler) rejectPage(req *collect.Request) {
+	e.stats.rejected.Add(1)
+	e.Logger.Debug("max pages reached",
+		zap.String("task", req.Task.Name),
+		zap.String("url", req.Url),
+	)
+}
+
+func (e *Crawler) taskPages(name string) *atomic.Int64 {
+	v, _ := e.pages.LoadOrStore(name, new(atomic.Int64))
+	return v.(*atomic.Int64)
+}
+
+func (e *Crawler) pagesExhausted(task *collect.Task) bool {
+	return task != nil && task.MaxPages > 0 && e.taskPages(task.Name).Load() >= task.MaxPages
+}
+
+// takePage 占用任务的一个页面数额度, 额度用尽时返回 false
+func (e *Crawler) takePage(task *collect.Task) bool {
+	if task == nil || task.MaxPages <= 0 {
+		return true
+	}
+	pages := e.taskPages(task.Name)
+	if pages.Add(1) > task.MaxPages {
+		pages.Add(-1)
+		return false
+	}
+	return true
+}
+
+func (e *Crawler) enqueue(reqs []*collect.Request) {
+	if len(reqs) == 0 {
+		return
 	}
-	go e.scheduler.Schedule()
+	e.stats.addPending(len(reqs))
+	e.track(reqs...)
 	go e.scheduler.Push(reqs...)
+}
 
+// delay 等待 d 后将请求重新放入调度器, 等待期间也计入待处理的请求, 避免被误判为爬取结束
+func (e *Crawler) delay(req *collect.Request, d time.Duration) {
+	e.stats.pending.Add(1)
+	e.track(req)
+	time.AfterFunc(d, func() {
+		e.scheduler.Push(req)
+	})
 }
 
 func (e *Crawler) CreateWork() {
 	// 获取任务,然后执行, 解析
 	for {
-		// 接收到调度器分配的任务；
+		// 接收到调度器分配的任务；调度器停止后返回 nil
 		req := e.scheduler.Pull()
-		if err := req.Check(); err != nil {
-			e.Logger.Error("check failed", zap.Error(err))
-			continue
+		if req == nil {
+			return
 		}
-		// 判断是否已经访问过
-		if !req.Task.Reload && e.HasVisited(req) {
-			e.Logger.Debug("request has visited",
-				zap.String("url", req.Url),
-			)
-			continue
+		e.handle(req)
+		e.untrack(req)
+		// 队列中没有任务, 也没有正在处理的任务时, 爬取结束
+		if e.stats.donePending() {
+			e.finished.Store(true)
+			e.finish()
 		}
-		e.StoreVisited(req)
+	}
+}
 
-		// 访问服务器
-		body, err := req.Task.Fetcher.Get(req)
-		if err != nil {
-			e.Logger.Error(
-				"can't fetch ",
-				zap.Error(err),
-				zap.String("url", req.Url),
-			)
-			e.SetFailure(req)
-			continue
-		}
-		if len(body) < 6000 {
-			e.Logger.Error(
-				"can't fetch",
-				zap.Int("length", len(body)),
-				zap.String("url", req.Url),
-			)
-			e.SetFailure(req)
-			continue
+func (e *Crawler) handle(req *collect.Request) {
+	if err := req.Check(); err != nil {
+		e.Logger.Error("check failed", zap.Error(err))
+		return
+	}
+	// 判断是否已经访问过, 重试的请求在首次处理时已经记录过
+	checkVisited := !req.Task.Reload && req.Attempt == 0
+	// 只有首次抓取时占用页面数额度
+	charge := req.Attempt == 0
+	// 从断点恢复的请求保存时可能正在处理, 已经被记录为访问过并占用了额度
+	if _, ok := e.resumed.LoadAndDelete(req); ok {
+		checkVisited = false
+		charge = charge && (req.Task.Reload || !e.HasVisited(req))
+	}
+	// 重新放入的死信已经访问过, 布隆过滤器等去重器不支持删除, 因此不检查是否访问过
+	_, reinjected := e.reinjected.LoadAndDelete(req)
+	if reinjected {
+		charge = false
+	}
+	if checkVisited && !reinjected && e.HasVisited(req) {
+		e.Logger.Debug("request has visited",
+			zap.String("url", req.Url),
+		)
+		e.stats.duplicates.Add(1)
+		return
+	}
+	host := hostOf(req.Url)
+	if req.Task.RobotsTxt && !e.allowedByRobots(req, host) {
+		return
+	}
+	// 站点冷却中或达到并发上限时, 延迟该请求, 先处理其它站点的请求
+	if wait := e.politeness.Acquire(host, e.HostLimit.merge(TaskHostLimit(req.Task))); wait > 0 {
+		e.delay(req, wait)
+		return
+	}
+	defer e.politeness.Release(host)
+	if checkVisited && !e.visit(req) && !reinjected {
+		// 其它 worker 已经处理了相同的请求
+		e.stats.duplicates.Add(1)
+		return
+	}
+	if charge && !e.takePage(req.Task) {
+		e.rejectPage(req)
+		return
+	}
+
+	// 访问服务器
+	fetchTime := time.Now()
+	resp, err := req.Task.Fetcher.Get(req.WithContext(e.fetchCtx))
+	if err != nil {
+		if e.fetchCtx.Err() != nil {
+			e.keep(req)
+			return
 		}
+		e.Logger.Error(
+			"can't fetch ",
+			zap.Error(err),
+			zap.String("url", req.Url),
+		)
+		e.SetFailure(req, err)
+		return
+	}
+	if err := req.Task.Validate(resp); err != nil {
+		e.Logger.Error(
+			"invalid response",
+			zap.Error(err),
+			zap.Int("status", resp.StatusCode),
+			zap.Int("length", len(resp.Body)),
+			zap.String("url", req.Url),
+		)
+		e.cooldown(host, err)
+		e.SetFailure(req, err)
+		return
+	}
 
-		rule := req.Task.Rule.Trunk[req.RuleName]
+	rule, ok := req.Task.Rule.Trunk[req.RuleName]
+	if !ok {
+		e.Logger.Error("rule not found",
+			zap.String("rule", req.RuleName),
+			zap.String("url", req.Url),
+		)
+		e.stats.failures.Add(1)
+		return
+	}
 
-		result, err := rule.ParseFunc(&collect.Context{
-			Body: body,
-			Req:  req,
-		})
+	result, err := rule.ParseFunc(&collect.Context{
+		Body: resp.Body,
+		Req:  req,
+		Resp: resp,
+	})
+
+	if err != nil {
+		e.Logger.Error("ParseFunc failed ",
+			zap.Error(err),
+			zap.String("url", req.Url),
+		)
+		e.stats.failures.Add(1)
+		return
+	}
+	e.stats.success.Add(1)
 
-		if err != nil {
-			e.Logger.Error("ParseFunc failed ",
-				zap.Error(err),
-				zap.String("url", req.Url),
-			)
-			continue
+	// 补全数据的来源和抓取时间
+	for _, item := range result.Items {
+		if item.Task == nil {
+			item.Task = req.Task
 		}
-
-		//解析服务器返回的数据
-		if len(result.Requesrts) > 0 {
-			go e.scheduler.Push(result.Requesrts...)
+		if item.Req == nil {
+			item.Req = req
+		}
+		if item.Rule == "" {
+			item.Rule = req.RuleName
+		}
+		if item.Time.IsZero() {
+			item.Time = fetchTime
 		}
-		// 将返回的数据发送到 out 通道中，方便后续的处理。
-		e.out <- result
 	}
+
+	//解析服务器返回的数据
+	if len(result.Requesrts) > 0 {
+		e.Push(result.Requesrts...)
+	}
+	// 将返回的数据发送到 out 通道中，方便后续的处理。
+	select {
+	case e.out <- result:
+	case <-e.abandon:
+		// 关闭超时后 HandleResult 已经退出, 丢弃结果
+		e.keep(req)
+	}
+}
+
+// keep 关闭超时后被中止的请求仍然作为尚未完成的请求保存到断点中, 恢复后重新抓取
+func (e *Crawler) keep(req *collect.Request) {
+	e.track(req)
 }
 
+// HandleResult 接收所有 worker 解析后的数据, 直到 out 被关闭或关闭超时
 func (e *Crawler) HandleResult() {
 	for {
+		var result collect.ParseResult
 		select {
-		// 接收所有 worker 解析后的数据
-		case result := <-e.out:
-			//包含了我们实际希望得到的结果，所以我们先用日志把结果打印出来
+		case r, ok := <-e.out:
+			if !ok {
+				e.flush()
+				return
+			}
+			result = r
+		case <-e.abandon:
+			e.flush()
+			return
+		}
+		e.stats.items.Add(int64(len(result.Items)))
+		if e.Storage == nil {
 			for _, item := range result.Items {
-				// todo: store
-				e.Logger.Sugar().Info("get result", item)
+				e.Logger.Info("get result",
+					zap.String("table", item.GetTableName()),
+					zap.String("url", item.GetUrl()),
+					zap.Any("data", item.Data),
+				)
 			}
+			continue
 		}
+		if err := e.Storage.Save(result.Items...); err != nil {
+			e.Logger.Error("save result failed", zap.Error(err))
+		}
+	}
+}
+
+// flush 退出前写入缓存的数据
+func (e *Crawler) flush() {
+	if e.Storage != nil {
+		if err := e.Storage.Flush(); err != nil {
+			e.Logger.Error("flush storage failed", zap.Error(err))
+		}
+	}
+}
+
+// allowedByRobots 判断 robots.txt 是否允许访问, 并将 Crawl-delay 应用到站点的访问限制中
+func (e *Crawler) allowedByRobots(req *collect.Request, host string) bool {
+	allowed, err := e.Robots.Allowed(req.Url)
+	if err != nil {
+		e.Logger.Error("check robots.txt failed",
+			zap.Error(err),
+			zap.String("url", req.Url),
+		)
+		e.stats.disallowed.Add(1)
+		return false
+	}
+	if !allowed {
+		e.Logger.Debug("disallowed by robots.txt",
+			zap.String("url", req.Url),
+		)
+		e.stats.disallowed.Add(1)
+		return false
+	}
+	if delay, err := e.Robots.CrawlDelay(req.Url); err == nil && delay > 0 {
+		e.politeness.SetDelay(host, delay)
+	}
+	return true
+}
+
+// cooldown 服务器要求稍后再试或返回封禁页面时, 暂停访问该站点
+func (e *Crawler) cooldown(host string, err error) {
+	var statusErr *collect.StatusError
+	if errors.As(err, &statusErr) && statusErr.RetryAfter > 0 {
+		e.politeness.Cooldown(host, statusErr.RetryAfter)
+		return
+	}
+	if errors.Is(err, collect.ErrBlocked) && e.BlockCooldown > 0 {
+		e.Logger.Warn("block page detected, cooldown host",
+			zap.String("host", host),
+			zap.Duration("cooldown", e.BlockCooldown),
+		)
+		e.politeness.Cooldown(host, e.BlockCooldown)
 	}
 }
 
-func (e *Crawler) SetFailure(req *collect.Request) {
+// SetFailure 按照任务的重试策略延迟重试失败的请求, 重试次数用尽后放入死信队列
+func (e *Crawler) SetFailure(req *collect.Request, err error) {
+	req.Attempt++
+	policy := req.RetryPolicy()
+	if policy.ShouldRetry(req.Attempt, err) {
+		delay := policy.Backoff(req.Attempt)
+		var statusErr *collect.StatusError
+		if errors.As(err, &statusErr) && statusErr.RetryAfter > delay {
+			delay = statusErr.RetryAfter
+		}
+		e.Logger.Debug("retry request",
+			zap.String("url", req.Url),
+			zap.Int("attempt", req.Attempt),
+			zap.Duration("delay", delay),
+		)
+		e.stats.retries.Add(1)
+		e.stats.requests.Add(1)
+		e.delay(req, delay)
+		return
+	}
+	e.stats.failures.Add(1)
+	e.deadLetters.Add(req, err)
+	// 放入死信队列的请求可以被重新发现, Reinject 不依赖删除
 	if !req.Task.Reload {
-		e.VisitedLock.Lock()
-		unique := req.Unique()
-		delete(e.Visited, unique)
-		e.VisitedLock.Unlock()
-	}
-	e.failureLock.Lock()
-	defer e.failureLock.Unlock()
-	if _, ok := e.failures[req.Unique()]; !ok {
-		// 首次失败时，再重新执行一次
-		e.failures[req.Unique()] = req
-		e.scheduler.Push(req)
+		if err := e.deduper(req).Remove(req.Unique()); err != nil {
+			e.Logger.Error("remove visited failed", zap.Error(err))
+		}
+	}
+}
+
+// deduper 请求使用的去重器, 任务未指定时使用引擎的去重器
+func (e *Crawler) deduper(r *collect.Request) dedup.Deduper {
+	if r.Task.Deduper != nil {
+		return r.Task.Deduper
 	}
-	// todo: 失败2次，加载到失败队列中
+	return e.Deduper
 }
 
 func (e *Crawler) HasVisited(r *collect.Request) bool {
-	e.VisitedLock.Lock()
-	defer e.VisitedLock.Unlock()
-	unique := r.Unique()
-	return e.Visited[unique]
+	ok, err := e.deduper(r).Contains(r.Unique())
+	if err != nil {
+		e.Logger.Error("check visited failed", zap.Error(err))
+	}
+	return ok
 }
 
 func (e *Crawler) StoreVisited(reqs ...*collect.Request) {
-	e.VisitedLock.Lock()
-	defer e.VisitedLock.Unlock()
-
 	for _, r := range reqs {
-		unique := r.Unique()
-		e.Visited[unique] = true
+		e.visit(r)
 	}
 }
 
+// visit 记录请求已访问, 请求已经被记录过时返回 false
+func (e *Crawler) visit(r *collect.Request) bool {
+	added, err := e.deduper(r).Add(r.Unique())
+	if err != nil {
+		e.Logger.Error("store visited failed", zap.Error(err))
+		return true
+	}
+	return added
+}
+
 type Scheduler interface {
-	Schedule()                //启动调度器
-	Push(...*collect.Request) //将请求放入到调度器中
-	Pull() *collect.Request   //从调度器中获取请求
+	Schedule(ctx context.Context) //启动调度器, ctx 取消后停止调度
+	Push(...*collect.Request)     //将请求放入到调度器中
+	Pull() *collect.Request       //从调度器中获取请求, 调度器停止后返回 nil
 }
 
 type Schedule struct {
 	requestCh   chan *collect.Request //负责接收请求
 	workerCh    chan *collect.Request //负责分配任务给 worker
+	done        chan struct{}         //调度器停止后关闭
 	priReqQueue []*collect.Request
 	reqQueue    []*collect.Request
 	Logger      *zap.Logger
@@ -305,15 +808,17 @@ func NewSchedule() *Schedule {
 	workerCh := make(chan *collect.Request)
 	s.requestCh = requestCh
 	s.workerCh = workerCh
+	s.done = make(chan struct{})
 	return s
 }
 
-func (s *Schedule) Schedule() {
+func (s *Schedule) Schedule(ctx context.Context) {
 	var req *collect.Request
 	var ch chan *collect.Request
 	// 从请求管道获取任务,添加到队列
 	// 从队列中获取任务, 发送到执行管道
 	go func() {
+		defer close(s.done)
 		for {
 			if req == nil && len(s.priReqQueue) > 0 {
 				req = s.priReqQueue[0]
@@ -328,6 +833,8 @@ func (s *Schedule) Schedule() {
 				ch = s.workerCh
 			}
 			select {
+			case <-ctx.Done():
+				return
 			case r := <-s.requestCh:
 				if r.Priority > 0 {
 					s.priReqQueue = append(s.priReqQueue, r)
@@ -347,11 +854,19 @@ func (s *Schedule) Schedule() {
 
 func (s *Schedule) Push(reqs ...*collect.Request) {
 	for _, req := range reqs {
-		s.requestCh <- req
+		select {
+		case s.requestCh <- req:
+		case <-s.done:
+			return
+		}
 	}
 }
 
 func (s *Schedule) Pull() *collect.Request {
-	r := <-s.workerCh
-	return r
+	select {
+	case r := <-s.workerCh:
+		return r
+	case <-s.done:
+		return nil
+	}
 }
@@ -0,0 +1,60 @@
+package engine
+
+import (
+	"sync/atomic"
+	"time"
+)
+
+// Stats 爬取的统计信息
+type Stats struct {
+	Requests   int64         // 进入调度器的请求数(含重试)
+	Success    int64         // 抓取并解析成功的请求数
+	Failures   int64         // 最终失败的请求数
+	Retries    int64         // 重试的次数
+	Duplicates int64         // 因已访问而跳过的请求数
+	Disallowed int64         // 被 robots.txt 禁止访问的请求数
+	Rejected   int64         // 超出任务抓取范围或页面数上限而丢弃的请求数
+	Items      int64         // 解析得到的数据条数
+	Pending    int64         // 尚未处理完成的请求数
+	Duration   time.Duration // 运行时长
+}
+
+type counter struct {
+	requests   atomic.Int64
+	success    atomic.Int64
+	failures   atomic.Int64
+	retries    atomic.Int64
+	duplicates atomic.Int64
+	disallowed atomic.Int64
+	rejected   atomic.Int64
+	items      atomic.Int64
+	// 队列中、正在处理以及正在推送的请求数, 归零时表示爬取结束
+	pending atomic.Int64
+	start   time.Time
+}
+
+func (c *counter) addPending(n int) {
+	c.requests.Add(int64(n))
+	c.pending.Add(int64(n))
+}
+
+// donePending 标记一个请求处理完成, 返回是否已经没有待处理的请求
+func (c *counter) donePending() bool {
+	return c.pending.Add(-1) == 0
+}
+
+// Stats 返回当前的统计信息
+func (e *Crawler) Stats() Stats {
+	return Stats{
+		Requests:   e.stats.requests.Load(),
+		Success:    e.stats.success.Load(),
+		Failures:   e.stats.failures.Load(),
+		Retries:    e.stats.retries.Load(),
+		Duplicates: e.stats.duplicates.Load(),
+		Disallowed: e.stats.disallowed.Load(),
+		Rejected:   e.stats.rejected.Load(),
+		Items:      e.stats.items.Load(),
+		Pending:    e.stats.pending.Load(),
+		Duration:   time.Since(e.stats.start),
+	}
+}
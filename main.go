@@ -1,17 +1,22 @@
 package main
 
 import (
+	"context"
 	"github.com/funbinary/crawler/collect"
 	"github.com/funbinary/crawler/engine"
 	"github.com/funbinary/crawler/log"
 	"github.com/funbinary/crawler/proxy"
+	"github.com/funbinary/crawler/robots"
+	"github.com/funbinary/crawler/storage/sqlstorage"
+	"go.uber.org/zap"
 	"go.uber.org/zap/zapcore"
+	"os"
+	"os/signal"
 	"runtime"
-
+	"syscall"
 	"time"
 )
 
-// xpath
 func main() {
 	plugin := log.NewStdoutPlugin(zapcore.InfoLevel)
 	logger := log.NewLogger(plugin)
@@ -23,11 +28,28 @@ func main() {
 		logger.Error("RoundRobinProxySwitcher failed")
 	}
 
-	var f collect.Fetcher = &collect.BrowserFetch{
+	// 重复抓取时使用条件请求, 内容未变化时不再下载
+	cache, err := collect.NewDiskCache("cache")
+	if err != nil {
+		logger.Error("create cache failed", zap.Error(err))
+		return
+	}
+
+	browser := &collect.BrowserFetch{
 		Timeout: 3000 * time.Millisecond,
 		Logger:  logger,
 		Proxy:   p,
+		// 链接来自抓取到的页面和动态规则, 禁止访问内网地址
+		Transport: collect.TransportConfig{Guard: &collect.NetworkGuard{}},
 	}
+	var f collect.Fetcher = collect.Chain(
+		browser,
+		collect.Logging(logger),
+		collect.Cache(cache, 0),
+		collect.Sessions(),
+		collect.TaskCookie(),
+		collect.RandomUA(),
+	)
 
 	// douban cookie
 	var seeds = make([]*collect.Task, 0, 1000)
@@ -38,13 +60,32 @@ func main() {
 		Fetcher: f,
 	})
 
+	store, err := sqlstorage.New(
+		sqlstorage.WithSqlUrl("crawler.db"),
+		sqlstorage.WithLogger(logger),
+		sqlstorage.WithBatchCount(2),
+	)
+	if err != nil {
+		logger.Error("create sqlstorage failed", zap.Error(err))
+		return
+	}
+	defer store.Close()
+
 	s := engine.NewEngine(
 		engine.WithLogger(logger),
 		engine.WithFetcher(f),
 		engine.WithSeeds(seeds),
 		engine.WithWorkCount(runtime.NumCPU()),
 		engine.WithScheduler(engine.NewSchedule()),
+		engine.WithStorage(store),
+		// robots.txt 与页面使用相同的代理和内网地址限制
+		engine.WithRobots(robots.NewManager(robots.WithClient(browser.Client()))),
 	)
-	s.Run()
+
+	// 收到 SIGINT/SIGTERM 后优雅退出
+	ctx, stop := signal.NotifyContext(context.Background(), os.Interrupt, syscall.SIGTERM)
+	defer stop()
+	stats, err := s.Run(ctx)
+	logger.Info("crawler exit", zap.Any("stats", stats), zap.Error(err))
 
 }
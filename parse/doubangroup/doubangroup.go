@@ -3,18 +3,36 @@ package doubangroup
 import (
 	"fmt"
 	"github.com/funbinary/crawler/collect"
-	"regexp"
+	"strings"
 	"time"
 )
 
-const urlListRe = `(https://www.douban.com/group/topic/[0-9a-z]+/)"[^>]*>([^<]+)</a>`
-const ContentRe = `<div class="topic-content">[\s\S]*?阳台[\s\S]*?<div class="aside">`
+// 小组讨论列表中的话题链接
+const TopicSelector = `table.olt td.title a`
+
+// 话题正文
+const ContentSelector = `div.topic-content`
 
 var DoubangroupTask = &collect.Task{
 	Property: collect.Property{
-		Name:     "find_douban_sun_room",
-		Cookie:   "ll=\"118201\"; __utmc=30149280; push_noty_num=0; push_doumail_num=0; __utmv=30149280.21545; __yadk_uid=CY4XlZtUkKWowjb53K8SISQTgqj8YOOU; douban-fav-remind=1; frodotk_db=\"8df2541269e216dca9d6fc373da64494\"; bid=dPuzdR0mG9M; gr_user_id=690ec6c6-4e7f-4277-b959-b829fd4aef5a; viewed=\"1007305_1475839_25913349\"; __gads=ID=613f831a31c6ac24-225718cbcadc0032:T=1679924466:RT=1679924466:S=ALNI_MaDEdHHhIEtazV6BqOobp1mDpI4Ug; __gpi=UID=00000be220b6ea7c:T=1679924466:RT=1680706111:S=ALNI_Mbp-472jjdHsL0xjpHPnuuWAacAEg; dbcl2=\"215458638:DJLz6+ZUdJ4\"; ck=V9Ki; _pk_ref.100001.8cb4=[\"\",\"\",1681392353,\"https://accounts.douban.com/\"]; _pk_id.100001.8cb4=bb24eb830bd259ee.1677888506.9.1681392353.1680706300.; _pk_ses.100001.8cb4=*; __utma=30149280.1773533084.1677888507.1680704158.1681392354.5; __utmz=30149280.1681392354.5.3.utmcsr=accounts.douban.com|utmccn=(referral)|utmcmd=referral|utmcct=/; __utmt=1; __utmb=30149280.7.5.1681392354",
+		Name:      "find_douban_sun_room",
+		CookieEnv: "DOUBAN_COOKIE",
+		Session: collect.SessionConfig{
+			StoreDir: "cookies",
+			LoginUrl: `accounts\.douban\.com/passport/login`,
+		},
 		WaitTime: 1 * time.Second,
+		// 页面中的广告链接会指向其它站点
+		AllowedDomains: []string{"douban.com"},
+		Normalizer: &collect.Normalizer{
+			// 话题链接上的 _i 参数只用于统计
+			StripQuery: []string{"utm_*", "_i", "_dtcc"},
+		},
+		ValidateRule: collect.ValidateRule{
+			MinSize: 6000,
+			// 访问过于频繁时豆瓣返回的提示页面
+			BlockPatterns: []string{"有异常请求从你的 IP 发出"},
+		},
 		MaxDepth: 5,
 	},
 	Rule: collect.RuleTree{
@@ -32,44 +50,47 @@ var DoubangroupTask = &collect.Task{
 			return roots, nil
 		},
 		Trunk: map[string]*collect.Rule{
-			"解析网站URL": {ParseURL},
-			"获取阳台房":   {GetSunRoom},
+			"解析网站URL": {ParseFunc: ParseURL},
+			// 与 TopicLinks 和 JS 任务中话题请求的 RuleName 一致, 原来的"获取阳台房"找不到对应的规则
+			"解析阳台房": {
+				ParseFunc: GetSunRoom,
+				ItemFields: []collect.Field{
+					{Name: "url", Type: collect.FieldString},
+					{Name: "title", Type: collect.FieldString},
+				},
+			},
 		},
 	},
 	Fetcher: nil,
 }
 
-func ParseURL(ctx *collect.Context) (collect.ParseResult, error) {
-	re := regexp.MustCompile(urlListRe)
-
-	matches := re.FindAllSubmatch(ctx.Body, -1)
-	result := collect.ParseResult{}
+// TopicLinks 小组讨论列表中的话题链接, 话题标题传递给下一级规则。
+// 列表中过长的标题会被截断, 完整标题在 title 属性中
+var TopicLinks = collect.LinkExtractor{
+	Selector:  TopicSelector,
+	Allow:     []string{`/group/topic/[0-9a-z]+/`},
+	Domains:   []string{"douban.com"},
+	RuleName:  "解析阳台房",
+	MetaAttrs: map[string]string{"title": "title"},
+}
 
-	for _, m := range matches {
-		u := string(m[1])
-		result.Requesrts = append(
-			result.Requesrts, &collect.Request{
-				Method:   "GET",
-				Task:     ctx.Req.Task,
-				Url:      u,
-				Depth:    ctx.Req.Depth + 1,
-				RuleName: "解析阳台房",
-			})
-	}
-	return result, nil
+func ParseURL(ctx *collect.Context) (collect.ParseResult, error) {
+	return collect.ParseResult{Requesrts: ctx.Links(TopicLinks)}, nil
 }
 
 func GetSunRoom(ctx *collect.Context) (collect.ParseResult, error) {
-	re := regexp.MustCompile(ContentRe)
-
-	ok := re.Match(ctx.Body)
-	if !ok {
+	if !strings.Contains(ctx.CSSText(ContentSelector), "阳台") {
 		return collect.ParseResult{
-			Items: []interface{}{},
+			Items: []*collect.DataCell{},
 		}, nil
 	}
 	result := collect.ParseResult{
-		Items: []interface{}{ctx.Req.Url},
+		Items: []*collect.DataCell{
+			ctx.Output(map[string]interface{}{
+				"url":   ctx.Req.Url,
+				"title": ctx.Req.Meta["title"],
+			}),
+		},
 	}
 	return result, nil
 }
@@ -0,0 +1,155 @@
+package robots
+
+import (
+	"io"
+	"net/http"
+	"net/url"
+	"sync"
+	"time"
+)
+
+type Option func(opts *options)
+
+type options struct {
+	Client    *http.Client  // 获取 robots.txt 的客户端, 应与抓取器使用相同的代理和 collect.NetworkGuard
+	UserAgent string        // 匹配 robots.txt 规则时使用的名称
+	TTL       time.Duration // robots.txt 的缓存时间
+	ErrorTTL  time.Duration // 获取失败时的缓存时间
+}
+
+var defaultOptions = options{
+	Client:    &http.Client{Timeout: 10 * time.Second},
+	UserAgent: "crawler",
+	TTL:       24 * time.Hour,
+	ErrorTTL:  time.Minute,
+}
+
+func WithClient(client *http.Client) Option {
+	return func(opts *options) {
+		opts.Client = client
+	}
+}
+
+func WithUserAgent(agent string) Option {
+	return func(opts *options) {
+		opts.UserAgent = agent
+	}
+}
+
+func WithTTL(ttl time.Duration) Option {
+	return func(opts *options) {
+		opts.TTL = ttl
+	}
+}
+
+// Manager 按站点获取并缓存 robots.txt
+type Manager struct {
+	mu    sync.Mutex
+	cache map[string]*entry // scheme://host -> robots.txt
+	options
+}
+
+type entry struct {
+	ready   chan struct{} // 获取完成后关闭
+	robots  *Robots
+	expires time.Time
+}
+
+func NewManager(opts ...Option) *Manager {
+	options := defaultOptions
+	for _, opt := range opts {
+		opt(&options)
+	}
+	return &Manager{
+		cache:   make(map[string]*entry),
+		options: options,
+	}
+}
+
+// Get 获取 rawUrl 所在站点的 robots.txt, 同一站点同时只会请求一次。
+// robots.txt 不存在时允许访问所有路径, 服务器错误或网络错误时在 ErrorTTL 内禁止访问。
+func (m *Manager) Get(rawUrl string) (*Robots, error) {
+	u, err := url.Parse(rawUrl)
+	if err != nil {
+		return nil, err
+	}
+	key := u.Scheme + "://" + u.Host
+
+	m.mu.Lock()
+	e, ok := m.cache[key]
+	if ok && (e.expires.IsZero() || time.Now().Before(e.expires)) {
+		m.mu.Unlock()
+		<-e.ready
+		return e.robots, nil
+	}
+	e = &entry{ready: make(chan struct{})}
+	m.cache[key] = e
+	m.mu.Unlock()
+
+	robots, ttl := m.fetch(key + "/robots.txt")
+	// 其它调用者会在持有锁时检查 expires
+	m.mu.Lock()
+	e.robots = robots
+	e.expires = time.Now().Add(ttl)
+	m.mu.Unlock()
+	close(e.ready)
+	return robots, nil
+}
+
+func (m *Manager) fetch(robotsUrl string) (*Robots, time.Duration) {
+	req, err := http.NewRequest("GET", robotsUrl, nil)
+	if err != nil {
+		return disallowAll, m.ErrorTTL
+	}
+	req.Header.Set("User-Agent", m.UserAgent)
+	resp, err := m.Client.Do(req)
+	if err != nil {
+		return disallowAll, m.ErrorTTL
+	}
+	defer resp.Body.Close()
+	switch {
+	case resp.StatusCode >= 500:
+		return disallowAll, m.ErrorTTL
+	case resp.StatusCode >= 400:
+		return allowAll, m.TTL
+	case resp.StatusCode != http.StatusOK:
+		return allowAll, m.ErrorTTL
+	}
+	// 与 Google 相同, 只解析前 500KiB
+	robots, err := Parse(io.LimitReader(resp.Body, MaxBodySize))
+	if err != nil {
+		return disallowAll, m.ErrorTTL
+	}
+	return robots, m.TTL
+}
+
+// Allowed 判断是否允许访问 rawUrl
+func (m *Manager) Allowed(rawUrl string) (bool, error) {
+	u, err := url.Parse(rawUrl)
+	if err != nil {
+		return false, err
+	}
+	robots, err := m.Get(rawUrl)
+	if err != nil {
+		return false, err
+	}
+	return robots.Allowed(m.UserAgent, u.RequestURI()), nil
+}
+
+// CrawlDelay rawUrl 所在站点要求的请求间隔
+func (m *Manager) CrawlDelay(rawUrl string) (time.Duration, error) {
+	robots, err := m.Get(rawUrl)
+	if err != nil {
+		return 0, err
+	}
+	return robots.CrawlDelay(m.UserAgent), nil
+}
+
+// Sitemaps rawUrl 所在站点在 robots.txt 中声明的 sitemap 地址, 可以作为种子请求
+func (m *Manager) Sitemaps(rawUrl string) ([]string, error) {
+	robots, err := m.Get(rawUrl)
+	if err != nil {
+		return nil, err
+	}
+	return robots.Sitemaps, nil
+}
@@ -0,0 +1,181 @@
+package robots
+
+import (
+	"bufio"
+	"io"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// Robots 解析后的 robots.txt
+type Robots struct {
+	groups   []*group
+	Sitemaps []string // robots.txt 中声明的 sitemap 地址
+}
+
+// group 对一组 User-agent 生效的规则
+type group struct {
+	agents     []string
+	rules      []rule
+	crawlDelay time.Duration
+}
+
+type rule struct {
+	allow   bool
+	pattern string
+}
+
+const (
+	MaxBodySize   = 500 << 10 // 获取 robots.txt 时最多读取的字节数
+	MaxRuleLength = 2048      // Allow 和 Disallow 规则的最大长度
+)
+
+var (
+	allowAll    = &Robots{}
+	disallowAll = &Robots{groups: []*group{{agents: []string{"*"}, rules: []rule{{allow: false, pattern: "/"}}}}}
+)
+
+// Parse 解析 robots.txt, 无法识别的行会被忽略
+func Parse(r io.Reader) (*Robots, error) {
+	robots := &Robots{}
+	var cur *group
+	// 连续的 User-agent 属于同一组
+	lastAgent := false
+	scanner := bufio.NewScanner(r)
+	// 过长的行不应导致整个文件解析失败
+	scanner.Buffer(make([]byte, 0, 4096), MaxBodySize)
+	for scanner.Scan() {
+		line := scanner.Text()
+		if i := strings.IndexByte(line, '#'); i >= 0 {
+			line = line[:i]
+		}
+		i := strings.IndexByte(line, ':')
+		if i < 0 {
+			continue
+		}
+		key := strings.ToLower(strings.TrimSpace(line[:i]))
+		value := strings.TrimSpace(line[i+1:])
+
+		switch key {
+		case "user-agent":
+			if !lastAgent {
+				cur = &group{}
+				robots.groups = append(robots.groups, cur)
+			}
+			cur.agents = append(cur.agents, strings.ToLower(value))
+			lastAgent = true
+			continue
+		case "allow", "disallow":
+			// Disallow 为空表示允许访问所有路径, 过长的规则会被忽略
+			if cur != nil && value != "" && len(value) <= MaxRuleLength {
+				cur.rules = append(cur.rules, rule{allow: key == "allow", pattern: value})
+			}
+		case "crawl-delay":
+			if cur != nil {
+				if seconds, err := strconv.ParseFloat(value, 64); err == nil && seconds > 0 {
+					cur.crawlDelay = time.Duration(seconds * float64(time.Second))
+				}
+			}
+		case "sitemap":
+			if value != "" {
+				robots.Sitemaps = append(robots.Sitemaps, value)
+			}
+		}
+		lastAgent = false
+	}
+	return robots, scanner.Err()
+}
+
+// group 返回对 agent 生效的规则组, 优先匹配名称最长的组, 其次是 *
+func (r *Robots) group(agent string) *group {
+	agent = strings.ToLower(agent)
+	var best *group
+	bestLen := -1
+	for _, g := range r.groups {
+		for _, a := range g.agents {
+			if a == "*" {
+				if bestLen < 0 {
+					best, bestLen = g, 0
+				}
+				continue
+			}
+			if strings.Contains(agent, a) && len(a) > bestLen {
+				best, bestLen = g, len(a)
+			}
+		}
+	}
+	return best
+}
+
+// Allowed 判断 agent 是否可以访问 path, path 包含查询参数。
+// 多条规则匹配时使用最长的规则, 长度相同时 Allow 优先。
+func (r *Robots) Allowed(agent, path string) bool {
+	if path == "" {
+		path = "/"
+	}
+	g := r.group(agent)
+	if g == nil {
+		return true
+	}
+	allowed := true
+	matchLen := -1
+	for _, rl := range g.rules {
+		if !match(rl.pattern, path) {
+			continue
+		}
+		if len(rl.pattern) > matchLen || (len(rl.pattern) == matchLen && rl.allow) {
+			allowed = rl.allow
+			matchLen = len(rl.pattern)
+		}
+	}
+	return allowed
+}
+
+// CrawlDelay agent 两次请求之间的最小间隔
+func (r *Robots) CrawlDelay(agent string) time.Duration {
+	g := r.group(agent)
+	if g == nil {
+		return 0
+	}
+	return g.crawlDelay
+}
+
+// match 判断路径是否匹配规则, 支持 * 通配符和表示结尾的 $
+func match(pattern, path string) bool {
+	end := strings.HasSuffix(pattern, "$")
+	if end {
+		pattern = pattern[:len(pattern)-1]
+	}
+	return matchFrom(pattern, path, end)
+}
+
+// matchFrom 贪心匹配, 只在最后一个 * 处回溯, 时间复杂度为 O(len(pattern)*len(path)),
+// 避免恶意的规则使匹配耗时随 * 的个数指数增长
+func matchFrom(pattern, path string, end bool) bool {
+	p, s := 0, 0
+	star, mark := -1, 0
+	for s < len(path) {
+		switch {
+		case p < len(pattern) && pattern[p] == '*':
+			star, mark = p, s
+			p++
+		case p < len(pattern) && pattern[p] == path[s]:
+			p++
+			s++
+		case p == len(pattern) && !end:
+			// 规则只需要匹配路径的前缀
+			return true
+		case star >= 0:
+			p = star + 1
+			mark++
+			s = mark
+		default:
+			return false
+		}
+	}
+	for p < len(pattern) && pattern[p] == '*' {
+		p++
+	}
+	return p == len(pattern)
+}
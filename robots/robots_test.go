@@ -0,0 +1,151 @@
+package robots_test
+
+import (
+	"github.com/funbinary/crawler/collect"
+	"github.com/funbinary/crawler/robots"
+	"github.com/stretchr/testify/require"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"sync"
+	"sync/atomic"
+	"testing"
+	"time"
+)
+
+const robotsTxt = `
+# comment
+User-agent: *
+Disallow: /search
+Disallow: /*.php$
+Allow: /search/about
+
+User-agent: Crawler
+User-agent: other
+Disallow: /private/
+Crawl-delay: 2.5
+
+Sitemap: https://example.com/sitemap.xml
+`
+
+func TestRobots(t *testing.T) {
+	r, err := robots.Parse(strings.NewReader(robotsTxt))
+	require.NoError(t, err)
+
+	tests := []struct {
+		agent   string
+		path    string
+		allowed bool
+	}{
+		{"somebot", "/", true},
+		{"somebot", "/search?q=1", false},
+		{"somebot", "/search/about", true},
+		{"somebot", "/index.php", false},
+		{"somebot", "/index.php?a=1", true},
+		{"crawler/1.0", "/search", true},
+		{"crawler/1.0", "/private/a", false},
+	}
+	for _, tt := range tests {
+		require.Equal(t, tt.allowed, r.Allowed(tt.agent, tt.path), "%s %s", tt.agent, tt.path)
+	}
+	require.Equal(t, 2500*time.Millisecond, r.CrawlDelay("crawler"))
+	require.Equal(t, time.Duration(0), r.CrawlDelay("somebot"))
+	require.Equal(t, []string{"https://example.com/sitemap.xml"}, r.Sitemaps)
+}
+
+func TestManagerConcurrent(t *testing.T) {
+	var requests atomic.Int64
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		requests.Add(1)
+		time.Sleep(10 * time.Millisecond)
+		w.Write([]byte(robotsTxt))
+	}))
+	defer server.Close()
+
+	m := robots.NewManager(robots.WithTTL(time.Millisecond))
+	var wg sync.WaitGroup
+	for i := 0; i < 20; i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			for j := 0; j < 5; j++ {
+				ok, err := m.Allowed(server.URL + "/private/a")
+				require.NoError(t, err)
+				require.False(t, ok)
+			}
+		}()
+	}
+	wg.Wait()
+	require.Greater(t, requests.Load(), int64(0))
+}
+
+func TestManagerGuard(t *testing.T) {
+	var requests atomic.Int64
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		requests.Add(1)
+		w.Write([]byte(robotsTxt))
+	}))
+	defer server.Close()
+
+	// 使用抓取器的客户端时, robots.txt 同样不能访问内网地址
+	f := &collect.BrowserFetch{Transport: collect.TransportConfig{Guard: &collect.NetworkGuard{}}}
+	m := robots.NewManager(robots.WithClient(f.Client()))
+	ok, err := m.Allowed(server.URL + "/")
+	require.NoError(t, err)
+	require.False(t, ok)
+	require.Zero(t, requests.Load())
+}
+
+func TestRobotsWildcard(t *testing.T) {
+	r, err := robots.Parse(strings.NewReader(`
+User-agent: *
+Disallow: /*a*a*a*a*a*a*a*a*a*a*a*a*b
+Disallow: /*/end$
+Disallow: /x*y
+Allow: /x*y*z
+Disallow: /` + strings.Repeat("l", robots.MaxRuleLength) + `
+`))
+	require.NoError(t, err)
+
+	tests := []struct {
+		path    string
+		allowed bool
+	}{
+		{"/" + strings.Repeat("a", 12) + "b", false},
+		{"/aaab", true},
+		{"/a-a-a-a-a-a-a-a-a-a-a-a-b/c", false},
+		{"/a-a-a-a-a-a-a-a-a-a-a-a-c", true},
+		{"/dir/end", false},
+		{"/dir/end/", true},
+		{"/dir/x/end", false},
+		{"/x1y", false},
+		{"/xy/z", true},
+		{"/" + strings.Repeat("l", robots.MaxRuleLength), true},
+	}
+	for _, tt := range tests {
+		require.Equal(t, tt.allowed, r.Allowed("somebot", tt.path), tt.path)
+	}
+
+	// 匹配的耗时不会随 * 的个数指数增长
+	start := time.Now()
+	require.True(t, r.Allowed("somebot", "/"+strings.Repeat("a", 60)))
+	require.Less(t, time.Since(start), 100*time.Millisecond)
+}
+
+func TestManagerBodySize(t *testing.T) {
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		// 超出大小限制的规则会被忽略
+		w.Write([]byte("User-agent: *\nDisallow: /a\n"))
+		w.Write([]byte("# " + strings.Repeat("x", robots.MaxBodySize) + "\n"))
+		w.Write([]byte("Disallow: /b\n"))
+	}))
+	defer server.Close()
+
+	m := robots.NewManager()
+	ok, err := m.Allowed(server.URL + "/a")
+	require.NoError(t, err)
+	require.False(t, ok)
+	ok, err = m.Allowed(server.URL + "/b")
+	require.NoError(t, err)
+	require.True(t, ok)
+}
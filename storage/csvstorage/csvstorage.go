@@ -0,0 +1,195 @@
+package csvstorage
+
+import (
+	"encoding/csv"
+	"encoding/json"
+	"fmt"
+	"github.com/funbinary/crawler/collect"
+	"github.com/funbinary/crawler/storage"
+	"github.com/pkg/errors"
+	"go.uber.org/zap"
+	"os"
+	"path/filepath"
+	"strings"
+	"sync"
+	"time"
+)
+
+// CsvStorage 将数据追加写入 csv 文件, 每张表一个文件, 新文件会先根据规则声明的字段写入表头
+type CsvStorage struct {
+	files  map[string]*csvFile // 表名 -> 文件
+	buffer []*collect.DataCell
+	mu     sync.Mutex
+	options
+}
+
+type csvFile struct {
+	file   *os.File
+	writer *csv.Writer
+}
+
+func New(opts ...Option) (*CsvStorage, error) {
+	options := defaultOptions
+	for _, opt := range opts {
+		opt(&options)
+	}
+	if err := os.MkdirAll(options.Dir, 0755); err != nil {
+		return nil, errors.Wrap(err, "create csv storage dir error")
+	}
+	s := &CsvStorage{
+		files:   make(map[string]*csvFile),
+		options: options,
+	}
+	return s, nil
+}
+
+func (s *CsvStorage) Save(datas ...*collect.DataCell) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.buffer = append(s.buffer, datas...)
+	if len(s.buffer) >= s.BatchCount {
+		return s.flush()
+	}
+	return nil
+}
+
+func (s *CsvStorage) Flush() error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	return s.flush()
+}
+
+func (s *CsvStorage) Close() error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	err := s.flush()
+	for _, f := range s.files {
+		if cerr := f.file.Close(); err == nil {
+			err = cerr
+		}
+	}
+	s.files = make(map[string]*csvFile)
+	return err
+}
+
+// flush 写入缓存的数据, 某张表写入失败时该表剩余的数据保留在缓存中下次重试,
+// 其它表和已经写入的数据不受影响
+func (s *CsvStorage) flush() error {
+	if len(s.buffer) == 0 {
+		return nil
+	}
+	var retry []*collect.DataCell
+	var firstErr error
+	failed := make(map[string]bool)
+	written := make(map[string]*csvFile)
+	for _, cell := range s.buffer {
+		name := cell.GetTableName()
+		if failed[name] {
+			retry = append(retry, cell)
+			continue
+		}
+		f, err := s.open(cell)
+		if err == nil {
+			err = s.write(f, cell)
+		}
+		if err != nil {
+			if firstErr == nil {
+				firstErr = err
+			}
+			failed[name] = true
+			retry = append(retry, cell)
+			continue
+		}
+		written[name] = f
+	}
+	s.buffer = append(s.buffer[:0], retry...)
+	for _, f := range written {
+		f.writer.Flush()
+		if err := f.writer.Error(); err != nil && firstErr == nil {
+			firstErr = err
+		}
+	}
+	return firstErr
+}
+
+// write 将一条数据写入 csv.Writer 的缓存, 无法编码的数据记录日志后丢弃
+func (s *CsvStorage) write(f *csvFile, cell *collect.DataCell) error {
+	values, err := storage.Values(cell)
+	if err != nil {
+		s.Logger.Error("encode data failed",
+			zap.Error(err),
+			zap.String("table", cell.GetTableName()),
+		)
+		return nil
+	}
+	record := make([]string, 0, len(values))
+	for _, v := range values {
+		record = append(record, format(v))
+	}
+	return f.writer.Write(record)
+}
+
+// open 打开数据对应的 csv 文件, 新文件写入表头
+func (s *CsvStorage) open(cell *collect.DataCell) (*csvFile, error) {
+	name := cell.GetTableName()
+	if f, ok := s.files[name]; ok {
+		return f, nil
+	}
+	path := filepath.Join(s.Dir, fileName(name)+".csv")
+	file, err := os.OpenFile(path, os.O_CREATE|os.O_WRONLY|os.O_APPEND, 0644)
+	if err != nil {
+		return nil, errors.Wrap(err, "open csv storage file error")
+	}
+	f := &csvFile{file: file, writer: csv.NewWriter(file)}
+	info, err := file.Stat()
+	if err != nil {
+		file.Close()
+		return nil, err
+	}
+	if info.Size() == 0 {
+		var header []string
+		for _, field := range storage.Fields(cell) {
+			header = append(header, field.Name)
+		}
+		if err := f.writer.Write(header); err != nil {
+			file.Close()
+			return nil, err
+		}
+	}
+	s.files[name] = f
+	return f, nil
+}
+
+// fileName 表名来自任务和规则名, 替换其中的路径分隔符等字符, 保证文件在 Dir 中
+func fileName(table string) string {
+	return strings.Map(func(r rune) rune {
+		if r == '/' || r == '\\' || r == ':' || r < 0x20 {
+			return '_'
+		}
+		return r
+	}, table)
+}
+
+// format 将字段转换为 csv 中的文本, 复杂类型使用 json 编码
+func format(v interface{}) string {
+	switch val := v.(type) {
+	case nil:
+		return ""
+	case string:
+		return val
+	case time.Time:
+		if val.IsZero() {
+			return ""
+		}
+		return val.Format(time.RFC3339)
+	case fmt.Stringer:
+		return val.String()
+	case int, int64, float64, bool:
+		return fmt.Sprint(val)
+	}
+	b, err := json.Marshal(v)
+	if err != nil {
+		return fmt.Sprint(v)
+	}
+	return string(b)
+}
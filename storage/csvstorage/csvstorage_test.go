@@ -0,0 +1,127 @@
+package csvstorage_test
+
+import (
+	"encoding/csv"
+	"github.com/funbinary/crawler/collect"
+	"github.com/funbinary/crawler/storage/csvstorage"
+	"github.com/stretchr/testify/require"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+)
+
+func TestCsvStorage(t *testing.T) {
+	dir := t.TempDir()
+	s, err := csvstorage.New(
+		csvstorage.WithDir(dir),
+		csvstorage.WithBatchCount(2),
+	)
+	require.NoError(t, err)
+
+	task := &collect.Task{
+		Property: collect.Property{Name: "task"},
+		Rule: collect.RuleTree{
+			Trunk: map[string]*collect.Rule{
+				"book": {ItemFields: []collect.Field{
+					{Name: "title", Type: collect.FieldString},
+					{Name: "score", Type: collect.FieldFloat},
+				}},
+				"raw": {},
+			},
+		},
+	}
+	now := time.Date(2023, 1, 2, 3, 4, 5, 0, time.UTC)
+	output := func(rule string, data map[string]interface{}) *collect.DataCell {
+		ctx := &collect.Context{Req: &collect.Request{Task: task, RuleName: rule, Url: "http://a.com/" + rule}}
+		cell := ctx.Output(data)
+		cell.Time = now
+		return cell
+	}
+	read := func(table string) [][]string {
+		f, err := os.Open(filepath.Join(dir, table+".csv"))
+		require.NoError(t, err)
+		defer f.Close()
+		records, err := csv.NewReader(f).ReadAll()
+		require.NoError(t, err)
+		return records
+	}
+
+	require.NoError(t, s.Save(
+		output("book", map[string]interface{}{"title": "a", "score": 9.1}),
+		output("raw", map[string]interface{}{"title": "b"}),
+	))
+	require.NoError(t, s.Save(output("book", map[string]interface{}{"title": "c, d", "score": 8.0})))
+	// 第三条数据还在缓存中
+	require.Len(t, read("task_book"), 2)
+
+	require.NoError(t, s.Close())
+	require.Equal(t, [][]string{
+		{"task", "rule", "url", "time", "title", "score"},
+		{"task", "book", "http://a.com/book", "2023-01-02T03:04:05Z", "a", "9.1"},
+		{"task", "book", "http://a.com/book", "2023-01-02T03:04:05Z", "c, d", "8"},
+	}, read("task_book"))
+
+	// 未声明字段的规则, 数据以 json 存储
+	require.Equal(t, [][]string{
+		{"task", "rule", "url", "time", "data"},
+		{"task", "raw", "http://a.com/raw", "2023-01-02T03:04:05Z", `{"title":"b"}`},
+	}, read("task_raw"))
+
+	// 重新打开时追加写入, 不会重复写入表头
+	s, err = csvstorage.New(csvstorage.WithDir(dir))
+	require.NoError(t, err)
+	require.NoError(t, s.Save(output("raw", map[string]interface{}{"title": "e"})))
+	require.NoError(t, s.Close())
+	records := read("task_raw")
+	require.Len(t, records, 3)
+	require.Equal(t, `{"title":"e"}`, records[2][4])
+}
+
+func TestCsvStorageFailure(t *testing.T) {
+	dir := t.TempDir()
+	s, err := csvstorage.New(csvstorage.WithDir(dir))
+	require.NoError(t, err)
+
+	task := &collect.Task{Property: collect.Property{Name: "task"}}
+	output := func(rule string, data map[string]interface{}) *collect.DataCell {
+		ctx := &collect.Context{Req: &collect.Request{Task: task, RuleName: rule, Url: "http://a.com/" + rule}}
+		return ctx.Output(data)
+	}
+	count := func(table string) int {
+		f, err := os.Open(filepath.Join(dir, table+".csv"))
+		require.NoError(t, err)
+		defer f.Close()
+		records, err := csv.NewReader(f).ReadAll()
+		require.NoError(t, err)
+		return len(records) - 1
+	}
+
+	// 无法打开的文件只影响对应的表, 已经写入的数据不会重复写入
+	bad := filepath.Join(dir, "task_bad.csv")
+	require.NoError(t, os.Mkdir(bad, 0755))
+	require.NoError(t, s.Save(
+		output("good", map[string]interface{}{"n": 1}),
+		output("bad", map[string]interface{}{"n": 2}),
+		output("good", map[string]interface{}{"n": 3}),
+	))
+	require.Error(t, s.Flush())
+	require.Equal(t, 2, count("task_good"))
+
+	require.NoError(t, os.Remove(bad))
+	require.NoError(t, s.Close())
+	require.Equal(t, 2, count("task_good"))
+	require.Equal(t, 1, count("task_bad"))
+
+	// 任务名和规则名中的路径分隔符不能使文件写到 Dir 之外
+	sub := filepath.Join(dir, "sub")
+	s, err = csvstorage.New(csvstorage.WithDir(sub))
+	require.NoError(t, err)
+	task.Name = "../evil"
+	require.NoError(t, s.Save(output("x/../../y", map[string]interface{}{"n": 1})))
+	require.NoError(t, s.Close())
+	entries, err := os.ReadDir(sub)
+	require.NoError(t, err)
+	require.Len(t, entries, 1)
+	require.Equal(t, ".._evil_x_.._.._y.csv", entries[0].Name())
+}
@@ -0,0 +1,35 @@
+package csvstorage
+
+import "go.uber.org/zap"
+
+type Option func(opts *options)
+
+type options struct {
+	Logger     *zap.Logger
+	Dir        string // 输出目录, 每张表对应目录下的一个 csv 文件
+	BatchCount int
+}
+
+var defaultOptions = options{
+	Logger:     zap.NewNop(),
+	Dir:        "result",
+	BatchCount: 100,
+}
+
+func WithLogger(logger *zap.Logger) Option {
+	return func(opts *options) {
+		opts.Logger = logger
+	}
+}
+
+func WithDir(dir string) Option {
+	return func(opts *options) {
+		opts.Dir = dir
+	}
+}
+
+func WithBatchCount(batchCount int) Option {
+	return func(opts *options) {
+		opts.BatchCount = batchCount
+	}
+}
@@ -0,0 +1,97 @@
+package jsonstorage
+
+import (
+	"bufio"
+	"encoding/json"
+	"github.com/funbinary/crawler/collect"
+	"github.com/pkg/errors"
+	"go.uber.org/zap"
+	"os"
+	"sync"
+	"time"
+)
+
+// record 写入文件的一行数据
+type record struct {
+	Task string                 `json:"task"`
+	Rule string                 `json:"rule"`
+	Url  string                 `json:"url"`
+	Time time.Time              `json:"time"`
+	Data map[string]interface{} `json:"data"`
+}
+
+// JsonStorage 以 JSON Lines 格式将数据追加写入文件, 每条数据一行
+type JsonStorage struct {
+	file   *os.File
+	writer *bufio.Writer
+	buffer []*collect.DataCell
+	mu     sync.Mutex
+	options
+}
+
+func New(opts ...Option) (*JsonStorage, error) {
+	options := defaultOptions
+	for _, opt := range opts {
+		opt(&options)
+	}
+	f, err := os.OpenFile(options.FilePath, os.O_CREATE|os.O_WRONLY|os.O_APPEND, 0644)
+	if err != nil {
+		return nil, errors.Wrap(err, "open json storage file error")
+	}
+	s := &JsonStorage{
+		file:    f,
+		writer:  bufio.NewWriter(f),
+		options: options,
+	}
+	return s, nil
+}
+
+func (s *JsonStorage) Save(datas ...*collect.DataCell) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.buffer = append(s.buffer, datas...)
+	if len(s.buffer) >= s.BatchCount {
+		return s.flush()
+	}
+	return nil
+}
+
+func (s *JsonStorage) Flush() error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	return s.flush()
+}
+
+func (s *JsonStorage) Close() error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if err := s.flush(); err != nil {
+		s.file.Close()
+		return err
+	}
+	return s.file.Close()
+}
+
+func (s *JsonStorage) flush() error {
+	if len(s.buffer) == 0 {
+		return nil
+	}
+	enc := json.NewEncoder(s.writer)
+	for _, cell := range s.buffer {
+		r := record{
+			Task: cell.GetTaskName(),
+			Rule: cell.Rule,
+			Url:  cell.GetUrl(),
+			Time: cell.Time,
+			Data: cell.Data,
+		}
+		if err := enc.Encode(r); err != nil {
+			s.Logger.Error("encode data failed",
+				zap.Error(err),
+				zap.String("task", cell.GetTaskName()),
+			)
+		}
+	}
+	s.buffer = s.buffer[:0]
+	return s.writer.Flush()
+}
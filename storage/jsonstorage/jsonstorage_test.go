@@ -0,0 +1,82 @@
+package jsonstorage_test
+
+import (
+	"bufio"
+	"encoding/json"
+	"github.com/funbinary/crawler/collect"
+	"github.com/funbinary/crawler/storage/jsonstorage"
+	"github.com/stretchr/testify/require"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+)
+
+func TestJsonStorage(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "result.jsonl")
+	s, err := jsonstorage.New(
+		jsonstorage.WithFilePath(path),
+		jsonstorage.WithBatchCount(2),
+	)
+	require.NoError(t, err)
+
+	task := &collect.Task{Property: collect.Property{Name: "task"}}
+	now := time.Date(2023, 1, 2, 3, 4, 5, 0, time.UTC)
+	output := func(rule string, data map[string]interface{}) *collect.DataCell {
+		ctx := &collect.Context{Req: &collect.Request{Task: task, RuleName: rule, Url: "http://a.com/" + rule}}
+		cell := ctx.Output(data)
+		cell.Time = now
+		return cell
+	}
+
+	type record struct {
+		Task string                 `json:"task"`
+		Rule string                 `json:"rule"`
+		Url  string                 `json:"url"`
+		Time time.Time              `json:"time"`
+		Data map[string]interface{} `json:"data"`
+	}
+	read := func() []record {
+		f, err := os.Open(path)
+		require.NoError(t, err)
+		defer f.Close()
+		var records []record
+		scanner := bufio.NewScanner(f)
+		for scanner.Scan() {
+			var r record
+			require.NoError(t, json.Unmarshal(scanner.Bytes(), &r))
+			records = append(records, r)
+		}
+		require.NoError(t, scanner.Err())
+		return records
+	}
+
+	require.NoError(t, s.Save(
+		output("book", map[string]interface{}{"title": "a", "score": 9.1}),
+		output("raw", map[string]interface{}{"title": "b"}),
+	))
+	require.NoError(t, s.Save(output("book", map[string]interface{}{"title": "c"})))
+	// 第三条数据还在缓存中
+	require.Len(t, read(), 2)
+
+	require.NoError(t, s.Close())
+	records := read()
+	require.Len(t, records, 3)
+	require.Equal(t, record{
+		Task: "task",
+		Rule: "book",
+		Url:  "http://a.com/book",
+		Time: now,
+		Data: map[string]interface{}{"title": "a", "score": 9.1},
+	}, records[0])
+	require.Equal(t, "c", records[2].Data["title"])
+
+	// 重新打开时追加写入
+	s, err = jsonstorage.New(jsonstorage.WithFilePath(path))
+	require.NoError(t, err)
+	require.NoError(t, s.Save(output("raw", map[string]interface{}{"title": "d"})))
+	require.NoError(t, s.Close())
+	records = read()
+	require.Len(t, records, 4)
+	require.Equal(t, "d", records[3].Data["title"])
+}
@@ -0,0 +1,35 @@
+package jsonstorage
+
+import "go.uber.org/zap"
+
+type Option func(opts *options)
+
+type options struct {
+	Logger     *zap.Logger
+	FilePath   string
+	BatchCount int
+}
+
+var defaultOptions = options{
+	Logger:     zap.NewNop(),
+	FilePath:   "result.jsonl",
+	BatchCount: 100,
+}
+
+func WithLogger(logger *zap.Logger) Option {
+	return func(opts *options) {
+		opts.Logger = logger
+	}
+}
+
+func WithFilePath(path string) Option {
+	return func(opts *options) {
+		opts.FilePath = path
+	}
+}
+
+func WithBatchCount(batchCount int) Option {
+	return func(opts *options) {
+		opts.BatchCount = batchCount
+	}
+}
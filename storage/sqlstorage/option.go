@@ -0,0 +1,35 @@
+package sqlstorage
+
+import "go.uber.org/zap"
+
+type Option func(opts *options)
+
+type options struct {
+	Logger     *zap.Logger
+	SqlUrl     string // 数据库文件路径, 使用 sqlite 的 DSN 格式
+	BatchCount int
+}
+
+var defaultOptions = options{
+	Logger:     zap.NewNop(),
+	SqlUrl:     "crawler.db",
+	BatchCount: 100,
+}
+
+func WithLogger(logger *zap.Logger) Option {
+	return func(opts *options) {
+		opts.Logger = logger
+	}
+}
+
+func WithSqlUrl(sqlUrl string) Option {
+	return func(opts *options) {
+		opts.SqlUrl = sqlUrl
+	}
+}
+
+func WithBatchCount(batchCount int) Option {
+	return func(opts *options) {
+		opts.BatchCount = batchCount
+	}
+}
@@ -0,0 +1,266 @@
+package sqlstorage
+
+import (
+	"database/sql"
+	"encoding/json"
+	"fmt"
+	"github.com/funbinary/crawler/collect"
+	"github.com/funbinary/crawler/storage"
+	"github.com/mattn/go-sqlite3"
+	"github.com/pkg/errors"
+	"go.uber.org/zap"
+	"strings"
+	"sync"
+	"time"
+)
+
+// SqlStorage 使用内嵌的 sqlite 数据库存储数据, 每个规则一张表, 表的列由规则声明的字段决定
+type SqlStorage struct {
+	db     *sql.DB
+	tables map[string]map[string]struct{} // 已创建的表 -> 表中所有列的小写名称
+	buffer []*collect.DataCell
+	mu     sync.Mutex
+	options
+}
+
+func New(opts ...Option) (*SqlStorage, error) {
+	options := defaultOptions
+	for _, opt := range opts {
+		opt(&options)
+	}
+	db, err := sql.Open("sqlite3", options.SqlUrl)
+	if err != nil {
+		return nil, errors.Wrap(err, "open sqlite error")
+	}
+	if err := db.Ping(); err != nil {
+		db.Close()
+		return nil, errors.Wrap(err, "ping sqlite error")
+	}
+	s := &SqlStorage{
+		db:      db,
+		tables:  make(map[string]map[string]struct{}),
+		options: options,
+	}
+	return s, nil
+}
+
+func (s *SqlStorage) Save(datas ...*collect.DataCell) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.buffer = append(s.buffer, datas...)
+	if len(s.buffer) >= s.BatchCount {
+		return s.flush()
+	}
+	return nil
+}
+
+func (s *SqlStorage) Flush() error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	return s.flush()
+}
+
+func (s *SqlStorage) Close() error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if err := s.flush(); err != nil {
+		s.db.Close()
+		return err
+	}
+	return s.db.Close()
+}
+
+// flush 按表分组, 每张表在独立的事务中批量插入, 一张表写入失败不影响其它表。
+// 只有数据库繁忙等暂时性错误的数据会保留在缓存中下次重试, 其它错误的数据记录日志后丢弃
+func (s *SqlStorage) flush() error {
+	if len(s.buffer) == 0 {
+		return nil
+	}
+	var names []string
+	groups := make(map[string][]*collect.DataCell)
+	for _, cell := range s.buffer {
+		name := cell.GetTableName()
+		if _, ok := groups[name]; !ok {
+			names = append(names, name)
+		}
+		groups[name] = append(groups[name], cell)
+	}
+
+	var retry []*collect.DataCell
+	var firstErr error
+	for _, name := range names {
+		cells := groups[name]
+		err := s.insert(name, cells)
+		if err == nil {
+			continue
+		}
+		if firstErr == nil {
+			firstErr = err
+		}
+		if transient(err) {
+			retry = append(retry, cells...)
+			continue
+		}
+		s.Logger.Error("drop data",
+			zap.Error(err),
+			zap.String("table", name),
+			zap.Int("count", len(cells)),
+		)
+	}
+	s.buffer = append(s.buffer[:0], retry...)
+	return firstErr
+}
+
+// insert 在一个事务中插入同一张表的数据, 单条数据的非暂时性错误只丢弃该条数据
+func (s *SqlStorage) insert(name string, cells []*collect.DataCell) error {
+	fields := storage.Fields(cells[0])
+	tx, err := s.db.Begin()
+	if err != nil {
+		return err
+	}
+	columns, err := s.prepareTable(tx, name, fields)
+	if err != nil {
+		tx.Rollback()
+		return err
+	}
+	stmt, err := tx.Prepare(insertSql(name, fields))
+	if err != nil {
+		tx.Rollback()
+		return errors.Wrapf(err, "prepare insert into %s error", name)
+	}
+	for _, cell := range cells {
+		values, err := storage.Values(cell)
+		if err != nil {
+			s.Logger.Error("encode data failed", zap.Error(err), zap.String("table", name))
+			continue
+		}
+		for i, v := range values {
+			values[i] = sqlValue(v)
+		}
+		if _, err := stmt.Exec(values...); err != nil {
+			if transient(err) {
+				stmt.Close()
+				tx.Rollback()
+				return errors.Wrapf(err, "insert into %s error", name)
+			}
+			s.Logger.Error("insert data failed",
+				zap.Error(err),
+				zap.String("table", name),
+				zap.String("url", cell.GetUrl()),
+			)
+		}
+	}
+	stmt.Close()
+	if err := tx.Commit(); err != nil {
+		return errors.Wrapf(err, "commit %s error", name)
+	}
+	// 事务提交后表和新增的列才真正创建
+	s.tables[name] = columns
+	return nil
+}
+
+// prepareTable 根据规则声明的字段创建表, 已经存在的表添加规则新增的字段, 返回表的所有列
+func (s *SqlStorage) prepareTable(tx *sql.Tx, name string, fields []collect.Field) (map[string]struct{}, error) {
+	existing, ok := s.tables[name]
+	if !ok {
+		columns := []string{"id INTEGER PRIMARY KEY AUTOINCREMENT"}
+		for _, f := range fields {
+			columns = append(columns, quote(f.Name)+" "+sqlType(f.Type))
+		}
+		_, err := tx.Exec(fmt.Sprintf("CREATE TABLE IF NOT EXISTS %s (%s)", quote(name), strings.Join(columns, ", ")))
+		if err != nil {
+			return nil, errors.Wrapf(err, "create table %s error", name)
+		}
+		// 表可能在之前运行时已经创建
+		if existing, err = tableColumns(tx, name); err != nil {
+			return nil, err
+		}
+	}
+	columns := make(map[string]struct{}, len(existing)+len(fields))
+	for c := range existing {
+		columns[c] = struct{}{}
+	}
+	for _, f := range fields {
+		key := strings.ToLower(f.Name)
+		if _, ok := columns[key]; ok {
+			continue
+		}
+		_, err := tx.Exec(fmt.Sprintf("ALTER TABLE %s ADD COLUMN %s %s", quote(name), quote(f.Name), sqlType(f.Type)))
+		if err != nil {
+			return nil, errors.Wrapf(err, "add column %s to %s error", f.Name, name)
+		}
+		columns[key] = struct{}{}
+	}
+	return columns, nil
+}
+
+// tableColumns 表中所有列的小写名称
+func tableColumns(tx *sql.Tx, name string) (map[string]struct{}, error) {
+	rows, err := tx.Query(fmt.Sprintf("PRAGMA table_info(%s)", quote(name)))
+	if err != nil {
+		return nil, errors.Wrapf(err, "query columns of %s error", name)
+	}
+	defer rows.Close()
+	columns := make(map[string]struct{})
+	for rows.Next() {
+		var (
+			cid, notNull, pk int
+			column, typ      string
+			def              sql.NullString
+		)
+		if err := rows.Scan(&cid, &column, &typ, &notNull, &def, &pk); err != nil {
+			return nil, err
+		}
+		columns[strings.ToLower(column)] = struct{}{}
+	}
+	return columns, rows.Err()
+}
+
+// transient 数据库繁忙等稍后重试可能成功的错误
+func transient(err error) bool {
+	var e sqlite3.Error
+	if errors.As(err, &e) {
+		return e.Code == sqlite3.ErrBusy || e.Code == sqlite3.ErrLocked
+	}
+	return false
+}
+
+func insertSql(name string, fields []collect.Field) string {
+	columns := make([]string, 0, len(fields))
+	for _, f := range fields {
+		columns = append(columns, quote(f.Name))
+	}
+	placeholders := strings.TrimSuffix(strings.Repeat("?, ", len(fields)), ", ")
+	return fmt.Sprintf("INSERT INTO %s (%s) VALUES (%s)", quote(name), strings.Join(columns, ", "), placeholders)
+}
+
+func sqlType(t collect.FieldType) string {
+	switch t {
+	case collect.FieldInt, collect.FieldBool:
+		return "INTEGER"
+	case collect.FieldFloat:
+		return "REAL"
+	case collect.FieldTime:
+		return "DATETIME"
+	}
+	return "TEXT"
+}
+
+// sqlValue 将驱动不支持的复杂类型编码为 json
+func sqlValue(v interface{}) interface{} {
+	switch v.(type) {
+	case nil, string, []byte, bool, time.Time,
+		int, int8, int16, int32, int64, uint, uint8, uint16, uint32, uint64, float32, float64:
+		return v
+	}
+	b, err := json.Marshal(v)
+	if err != nil {
+		return fmt.Sprint(v)
+	}
+	return string(b)
+}
+
+// quote 将表名转换为 sql 标识符
+func quote(name string) string {
+	return `"` + strings.ReplaceAll(name, `"`, `""`) + `"`
+}
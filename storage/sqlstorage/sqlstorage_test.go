@@ -0,0 +1,126 @@
+package sqlstorage_test
+
+import (
+	"database/sql"
+	"github.com/funbinary/crawler/collect"
+	"github.com/funbinary/crawler/storage/sqlstorage"
+	"github.com/stretchr/testify/require"
+	"path/filepath"
+	"testing"
+)
+
+func TestSqlStorage(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "test.db")
+	s, err := sqlstorage.New(
+		sqlstorage.WithSqlUrl(path+"?_busy_timeout=10"),
+		sqlstorage.WithBatchCount(2),
+	)
+	require.NoError(t, err)
+
+	task := &collect.Task{
+		Property: collect.Property{Name: "task"},
+		Rule: collect.RuleTree{
+			Trunk: map[string]*collect.Rule{
+				"book": {ItemFields: []collect.Field{
+					{Name: "title", Type: collect.FieldString},
+					{Name: "score", Type: collect.FieldFloat},
+				}},
+				"raw": {},
+			},
+		},
+	}
+	output := func(rule string, data map[string]interface{}) *collect.DataCell {
+		ctx := &collect.Context{Req: &collect.Request{Task: task, RuleName: rule, Url: "http://a.com/" + rule}}
+		return ctx.Output(data)
+	}
+
+	require.NoError(t, s.Save(
+		output("book", map[string]interface{}{"title": "a", "score": 9.1}),
+		output("raw", map[string]interface{}{"title": "b"}),
+	))
+	require.NoError(t, s.Save(output("book", map[string]interface{}{"title": "c", "score": 8.0})))
+
+	db, err := sql.Open("sqlite3", path)
+	require.NoError(t, err)
+	defer db.Close()
+
+	count := func(table string) int {
+		var n int
+		require.NoError(t, db.QueryRow(`SELECT COUNT(*) FROM "`+table+`"`).Scan(&n))
+		return n
+	}
+	// 第三条数据还在缓存中
+	require.Equal(t, 1, count("task_book"))
+	require.Equal(t, 1, count("task_raw"))
+
+	// 数据库被锁定时缓存的数据不会丢失, 下次写入时重试
+	tx, err := db.Begin()
+	require.NoError(t, err)
+	_, err = tx.Exec(`DELETE FROM "task_raw" WHERE 0`)
+	require.NoError(t, err)
+	require.Error(t, s.Flush())
+	require.NoError(t, tx.Rollback())
+
+	require.NoError(t, s.Close())
+	require.Equal(t, 2, count("task_book"))
+
+	var score float64
+	require.NoError(t, db.QueryRow(`SELECT score FROM "task_book" WHERE title = 'a'`).Scan(&score))
+	require.Equal(t, 9.1, score)
+
+	// 未声明字段的规则, 数据以 json 存储
+	var data string
+	require.NoError(t, db.QueryRow(`SELECT data FROM "task_raw"`).Scan(&data))
+	require.Equal(t, `{"title":"b"}`, data)
+}
+
+func TestSqlStorageSchema(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "test.db")
+	rule := &collect.Rule{ItemFields: []collect.Field{{Name: "a", Type: collect.FieldString}}}
+	task := &collect.Task{
+		Property: collect.Property{Name: "t"},
+		Rule:     collect.RuleTree{Trunk: map[string]*collect.Rule{"r": rule, "other": {}}},
+	}
+	output := func(rule string, data map[string]interface{}) *collect.DataCell {
+		ctx := &collect.Context{Req: &collect.Request{Task: task, RuleName: rule, Url: "http://a.com/" + rule}}
+		return ctx.Output(data)
+	}
+
+	s, err := sqlstorage.New(sqlstorage.WithSqlUrl(path))
+	require.NoError(t, err)
+	require.NoError(t, s.Save(output("r", map[string]interface{}{"a": "1"})))
+	require.NoError(t, s.Close())
+
+	db, err := sql.Open("sqlite3", path)
+	require.NoError(t, err)
+	defer db.Close()
+
+	// 规则新增字段后, 重新打开时为已经存在的表添加列
+	rule.ItemFields = append(rule.ItemFields, collect.Field{Name: "b", Type: collect.FieldInt})
+	s, err = sqlstorage.New(sqlstorage.WithSqlUrl(path))
+	require.NoError(t, err)
+	require.NoError(t, s.Save(output("r", map[string]interface{}{"a": "2", "b": 3})))
+	require.NoError(t, s.Flush())
+	var b int
+	require.NoError(t, db.QueryRow(`SELECT b FROM "t_r" WHERE a = '2'`).Scan(&b))
+	require.Equal(t, 3, b)
+
+	// 无法写入的数据被丢弃, 不影响其它表和之后的写入
+	_, err = db.Exec(`CREATE TRIGGER fail BEFORE INSERT ON "t_r" BEGIN SELECT RAISE(ABORT, 'fail'); END`)
+	require.NoError(t, err)
+	require.NoError(t, s.Save(
+		output("r", map[string]interface{}{"a": "4"}),
+		output("other", map[string]interface{}{"c": 5}),
+	))
+	require.NoError(t, s.Flush())
+	_, err = db.Exec(`DROP TRIGGER fail`)
+	require.NoError(t, err)
+	require.NoError(t, s.Save(output("r", map[string]interface{}{"a": "6"})))
+	require.NoError(t, s.Close())
+
+	var rows int
+	require.NoError(t, db.QueryRow(`SELECT COUNT(*) FROM "t_r"`).Scan(&rows))
+	require.Equal(t, 3, rows)
+	require.NoError(t, db.QueryRow(`SELECT COUNT(*) FROM "t_other"`).Scan(&rows))
+	require.Equal(t, 1, rows)
+}
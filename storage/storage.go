@@ -0,0 +1,52 @@
+package storage
+
+import (
+	"encoding/json"
+	"github.com/funbinary/crawler/collect"
+)
+
+// 规则未声明字段时, 所有数据以 json 的形式存储在该列中
+const DataColumn = "data"
+
+// 每条数据都包含的列
+var CommonFields = []collect.Field{
+	{Name: "task", Type: collect.FieldString},
+	{Name: "rule", Type: collect.FieldString},
+	{Name: "url", Type: collect.FieldString},
+	{Name: "time", Type: collect.FieldTime},
+}
+
+// Storage 存储引擎, 负责保存规则解析后的数据
+type Storage interface {
+	Save(datas ...*collect.DataCell) error // 保存数据, 实现可以先缓存再批量写入
+	Flush() error                          // 将缓存的数据全部写入
+	Close() error                          // 写入剩余数据并释放资源
+}
+
+// Fields 数据对应的表的所有列, 由公共列和规则声明的字段组成
+func Fields(cell *collect.DataCell) []collect.Field {
+	schema := cell.Schema()
+	if len(schema) == 0 {
+		schema = []collect.Field{{Name: DataColumn, Type: collect.FieldString}}
+	}
+	fields := make([]collect.Field, 0, len(CommonFields)+len(schema))
+	fields = append(fields, CommonFields...)
+	return append(fields, schema...)
+}
+
+// Values 按 Fields 的顺序返回数据中每一列的值
+func Values(cell *collect.DataCell) ([]interface{}, error) {
+	values := []interface{}{cell.GetTaskName(), cell.Rule, cell.GetUrl(), cell.Time}
+	schema := cell.Schema()
+	if len(schema) == 0 {
+		b, err := json.Marshal(cell.Data)
+		if err != nil {
+			return nil, err
+		}
+		return append(values, string(b)), nil
+	}
+	for _, f := range schema {
+		values = append(values, cell.Data[f.Name])
+	}
+	return values, nil
+}